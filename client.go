@@ -1,33 +1,75 @@
 package kingdomauth
 
 import (
+	"crypto"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/keys"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// verificationKey is a public key together with the signing algorithms tokens verified with it may use.
+type verificationKey struct {
+	key  crypto.PublicKey
+	algs []string
+}
+
+// newVerificationKey creates a verification key. If alg is empty, the algorithms are derived from the key type.
+func newVerificationKey(pub crypto.PublicKey, alg string) (*verificationKey, error) {
+	if alg != "" {
+		return &verificationKey{key: pub, algs: []string{alg}}, nil
+	}
+
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return &verificationKey{key: pub, algs: []string{"RS256", "RS384", "RS512"}}, nil
+	}
+
+	method, err := keys.MethodFor(pub, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &verificationKey{key: pub, algs: []string{method.Alg()}}, nil
+}
+
+// minimum time between two fetches of the JWKS, so tokens with unknown kids can't make the client hammer kingdom-auth
+const jwksRefetchInterval = time.Minute
+
 // Client is a client for Kingdom Auth service, intended to be used by other services.
 type Client struct {
 	baseURL string
 	secret  string
 
 	providers []string
-	publicKey *rsa.PublicKey
+
+	// issuer of the tokens, read from the OpenID Connect discovery document
+	issuer string
+
+	// fixed public key, if one was given. Otherwise, keys are fetched from the JWKS of kingdom-auth.
+	publicKey *verificationKey
+
+	jwksMu        sync.RWMutex
+	jwks          map[string]*verificationKey
+	lastJWKSFetch time.Time
 
 	log *slog.Logger
 }
 
+// NewClient creates a client for the kingdom-auth instance at baseURL.
+//
+// If publicKeyPath is set, tokens are verified with that public key only. If it is empty, the keys are fetched from
+// /.well-known/jwks.json and looked up by the token's kid - this keeps working when kingdom-auth rotates its keys.
 func NewClient(baseURL string, secret string, publicKeyPath string) (*Client, error) {
 	log := slog.With("source", "kingdomauth.Client")
 
@@ -44,42 +86,77 @@ func NewClient(baseURL string, secret string, publicKeyPath string) (*Client, er
 		baseURL = baseURL[:len(baseURL)-1]
 	}
 
-	// Load public key
-	publicKeyData, err := os.ReadFile(publicKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	client := &Client{
+		baseURL:   baseURL,
+		secret:    secret,
+		providers: make([]string, 0),
+		jwks:      make(map[string]*verificationKey),
+		log:       log,
 	}
 
-	publicKeyBlock, _ := pem.Decode(publicKeyData)
-	if publicKeyBlock == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	if publicKeyPath != "" {
+		publicKey, err := keys.LoadPublicKey(publicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		client.publicKey, err = newVerificationKey(publicKey, "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := client.loadJWKS()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
 	}
 
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+	err := client.loadIssuer()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %w", err)
+		return nil, fmt.Errorf("failed to load issuer: %w", err)
 	}
 
-	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("not an RSA public key")
+	err = client.loadProviders()
+
+	if err != nil {
+		return nil, err
 	}
 
-	client := &Client{
-		baseURL:   baseURL,
-		secret:    secret,
-		providers: make([]string, 0),
-		publicKey: publicKey,
-		log:       log,
+	return client, nil
+}
+
+type discoveryAnswer struct {
+	Issuer string `json:"issuer"`
+}
+
+// loadIssuer reads the issuer of the tokens from /.well-known/openid-configuration.
+func (c *Client) loadIssuer() error {
+	resp, err := http.Get(c.baseURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
 	}
 
-	err = client.loadProviders()
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
 
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	var answer discoveryAnswer
+	err = json.NewDecoder(resp.Body).Decode(&answer)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return client, nil
+	if answer.Issuer == "" {
+		return fmt.Errorf("discovery document has no issuer")
+	}
+
+	c.issuer = answer.Issuer
+
+	return nil
 }
 
 type providersAnswer struct {
@@ -129,18 +206,106 @@ func (c *Client) loadProviders() error {
 	return nil
 }
 
+// loadJWKS replaces the known keys with the ones currently served by kingdom-auth.
+func (c *Client) loadJWKS() error {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+
+	c.lastJWKSFetch = time.Now()
+
+	resp, err := http.Get(c.baseURL + "/.well-known/jwks.json")
+	if err != nil {
+		return err
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	var set keys.JWKS
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]*verificationKey)
+	for _, k := range set.Keys {
+		pub, err := k.PublicKey()
+		if err == nil {
+			found[k.Kid], err = newVerificationKey(pub, k.Alg)
+		}
+
+		if err != nil {
+			c.log.Debug("skipping unsupported key from JWKS", "kid", k.Kid, "err", err)
+			delete(found, k.Kid)
+		}
+	}
+
+	c.jwks = found
+
+	return nil
+}
+
+// keyFor returns the public key to verify a token with.
+func (c *Client) keyFor(token *jwt.Token) (*verificationKey, error) {
+	if c.publicKey != nil {
+		return c.publicKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	c.jwksMu.RLock()
+	key, ok := c.jwks[kid]
+	canRefetch := time.Since(c.lastJWKSFetch) > jwksRefetchInterval
+	c.jwksMu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	// the key might be new - kingdom-auth could have rotated its keys since the last fetch
+	if canRefetch {
+		err := c.loadJWKS()
+		if err != nil {
+			c.log.Warn("failed to refresh JWKS", "err", err)
+		}
+
+		c.jwksMu.RLock()
+		key, ok = c.jwks[kid]
+		c.jwksMu.RUnlock()
+
+		if ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key id: %q", kid)
+}
+
 // ValidateToken validates a JWT token using the public key and returns the claims.
-// It verifies the signature and checks if the token is expired.
+// Tokens signed with RSA (RS256, RS384, RS512), ECDSA (ES256, ES384, ES512) and Ed25519 (EdDSA) keys are supported.
+// It verifies the signature, the issuer and the version and checks if the token is expired. Only auth tokens and tokens of service
+// clients are accepted - refresh tokens and tokens issued to OpenID Connect clients are rejected.
 // Returns jwt.MapClaims on success, or an error if validation fails.
 func (c *Client) ValidateToken(token string) (jwt.MapClaims, error) {
 	claims := jwt.MapClaims{}
 
 	tkn, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := c.keyFor(token)
+		if err != nil {
+			return nil, err
+		}
+
 		// Verify the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		if !slices.Contains(key.algs, token.Method.Alg()) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return c.publicKey, nil
+
+		return key.key, nil
 	})
 
 	if err != nil {
@@ -160,5 +325,53 @@ func (c *Client) ValidateToken(token string) (jwt.MapClaims, error) {
 		return nil, core.ErrTokenInvalid
 	}
 
+	if iss, _ := claims.GetIssuer(); iss != c.issuer || claims[core.KingdomAuthVersionClaim] != core.KingdomAuthVersion {
+		return nil, core.ErrTokenInvalid
+	}
+
+	// refresh tokens and tokens of OpenID Connect clients (like a wiki) must not be replayed against your services
+	if !core.IsAccessToken(claims) {
+		return nil, core.ErrTokenInvalid
+	}
+
 	return claims, nil
 }
+
+// HasPermission reports whether the claims of a validated auth token grant the given permission through any of the user's roles.
+func (c *Client) HasPermission(claims jwt.MapClaims, permission string) bool {
+	return slices.Contains(claimList(claims, core.PermissionsClaim), permission)
+}
+
+// HasRole reports whether the claims of a validated auth token contain the given role.
+func (c *Client) HasRole(claims jwt.MapClaims, role string) bool {
+	return slices.Contains(claimList(claims, core.RolesClaim), role)
+}
+
+// IsServiceToken reports whether the claims of a validated token belong to a service client (client credentials grant) instead of a user.
+// The subject of such a token is the client id.
+func (c *Client) IsServiceToken(claims jwt.MapClaims) bool {
+	return claims[core.SubjectTypeClaim] == core.SubjectTypeClient
+}
+
+// HasScope reports whether the claims of a validated service token or exchanged token grant the given scope.
+func (c *Client) HasScope(claims jwt.MapClaims, scope string) bool {
+	raw, _ := claims[core.ScopeClaim].(string)
+	return slices.Contains(strings.Fields(raw), scope)
+}
+
+// claimList reads a claim that holds a list of strings.
+func claimList(claims jwt.MapClaims, name string) []string {
+	raw, ok := claims[name].([]any)
+	if !ok {
+		return nil
+	}
+
+	list := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
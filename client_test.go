@@ -0,0 +1,129 @@
+package kingdomauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestServer serves the endpoints NewClient reads from kingdom-auth, with the keys of ring.
+func newTestServer(t *testing.T, ring *keys.Ring) *httptest.Server {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	var srv *httptest.Server
+
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, ring.JWKS())
+	})
+
+	r.GET("/.well-known/openid-configuration", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"issuer": srv.URL})
+	})
+
+	r.GET("/providers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": []string{}})
+	})
+
+	srv = httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestValidateTokenUse(t *testing.T) {
+	private, err := keys.Generate("ES256", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keys.NewKey(private, nil, "ES256", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := keys.NewRing(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t, ring)
+
+	client, err := NewClient(srv.URL, "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{
+			name: "auth token",
+			claims: jwt.MapClaims{"sub": "1", "iss": srv.URL, "exp": exp, core.TokenUseClaim: core.TokenUseAuth,
+				core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+			valid: true,
+		},
+		{
+			name: "service token",
+			claims: jwt.MapClaims{"sub": "billing", "aud": []string{"orders"}, "iss": srv.URL, "exp": exp,
+				core.SubjectTypeClaim: core.SubjectTypeClient, core.ClientIDClaim: "billing", core.ScopeClaim: "orders:read",
+				core.TokenUseClaim: core.TokenUseService, core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+			valid: true,
+		},
+		{
+			name: "refresh token",
+			claims: jwt.MapClaims{"sub": "1", "iss": srv.URL, "exp": exp, "jti": "session",
+				core.TokenUseClaim: core.TokenUseRefresh, core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+		},
+		{
+			name: "oidc access token",
+			claims: jwt.MapClaims{"sub": "1", "aud": "wiki", "iss": srv.URL, "exp": exp, core.ClientIDClaim: "wiki",
+				core.TokenUseClaim: core.TokenUseOIDCAccess, core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+		},
+		{
+			name:   "no token_use",
+			claims: jwt.MapClaims{"sub": "1", "iss": srv.URL, "exp": exp, core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+		},
+		{
+			name: "other issuer",
+			claims: jwt.MapClaims{"sub": "1", "iss": "https://other.example.com", "exp": exp, core.TokenUseClaim: core.TokenUseAuth,
+				core.KingdomAuthVersionClaim: core.KingdomAuthVersion},
+		},
+		{
+			name:   "no version",
+			claims: jwt.MapClaims{"sub": "1", "iss": srv.URL, "exp": exp, core.TokenUseClaim: core.TokenUseAuth},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := ring.Sign(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.ValidateToken(token)
+
+			if tt.valid && err != nil {
+				t.Errorf("ValidateToken() error = %v, want a valid token", err)
+			}
+
+			if !tt.valid && !errors.Is(err, core.ErrTokenInvalid) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, core.ErrTokenInvalid)
+			}
+		})
+	}
+}
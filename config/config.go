@@ -8,6 +8,11 @@ type OAuthConfig struct {
 	Name string `yaml:"name"`
 	Url  string `yaml:"url"`
 
+	// How users are identified with this provider.
+	// supported: "oidc", "github", "oauth2-generic"
+	// default: oidc
+	Type string `yaml:"type"`
+
 	SkipDiscovery bool `yaml:"skip_discovery"`
 
 	Endpoints struct {
@@ -21,6 +26,118 @@ type OAuthConfig struct {
 	ClientId     string   `yaml:"client_id"`
 	ClientSecret string   `yaml:"client_secret"`
 	Scopes       []string `yaml:"scopes"`
+
+	// Use PKCE (S256) for the login flow.
+	// supported: "true", "false", "auto"
+	// default: auto (use PKCE if the provider announces S256 support in its discovery document)
+	PKCE string `yaml:"pkce"`
+
+	// On the first login with this provider, attach to an existing user with the same verified email instead of creating
+	// a new one. Only enable this for providers that really verify emails.
+	TrustEmailForLinking bool `yaml:"trust_email_for_linking"`
+
+	// Where to find the identity in the userinfo response. Defaults to the standard OIDC claims.
+	Claims ClaimsConfig `yaml:"claims"`
+
+	// Claims copied into the userdata on login.
+	SyncClaims []SyncClaimConfig `yaml:"sync_claims"`
+
+	// Roles granted based on the groups of the user (see Claims.Groups), updated on every login.
+	GroupMappings []GroupMappingConfig `yaml:"group_mappings"`
+}
+
+// ClaimsConfig maps the claims of a provider to the user's identity. Each entry is a JSONPath-like selector into the
+// userinfo response, e.g. "sub", "$.profile.email" or "$.emails[0].value".
+type ClaimsConfig struct {
+	Subject       string `yaml:"subject"`
+	Email         string `yaml:"email"`
+	EmailVerified string `yaml:"email_verified"`
+	Name          string `yaml:"name"`
+	Avatar        string `yaml:"avatar"`
+	Groups        string `yaml:"groups"`
+}
+
+// SyncClaimConfig copies a claim of the provider into the user's userdata on login.
+type SyncClaimConfig struct {
+	// Selector of the claim, see ClaimsConfig.
+	Claim string `yaml:"claim"`
+
+	// supported: "public", "private"
+	// default: public
+	Target string `yaml:"target"`
+
+	// Userdata key to write to.
+	// default: the last key of the claim selector
+	Key string `yaml:"key"`
+
+	// supported: "always" (overwrite on every login), "first_login" (only on the first login of the user)
+	// default: always
+	Mode string `yaml:"mode"`
+}
+
+// GroupMappingConfig grants a role to users in a group of the provider.
+type GroupMappingConfig struct {
+	// Exact name of the group.
+	Group string `yaml:"group"`
+
+	// Regular expression matching the whole group name, instead of Group.
+	Regex string `yaml:"regex"`
+
+	// Role to grant.
+	Role string `yaml:"role"`
+}
+
+// ClientConfig registers an application that uses kingdom-auth as OpenID Connect provider.
+type ClientConfig struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+
+	// bcrypt hash of the secret of confidential clients, see "kingdom-auth clients secret". Public clients (e.g. single page
+	// or mobile apps) have none and have to use PKCE.
+	SecretHash string `yaml:"secret_hash"`
+
+	// Where the client may receive authorization codes. Matched exactly.
+	RedirectURIs []string `yaml:"redirect_uris"`
+}
+
+// ServiceClientConfig registers a backend that gets tokens for calling other services with the client credentials grant.
+type ServiceClientConfig struct {
+	// Must not be numeric, tokens of the client carry it as sub like user ids.
+	ID string `yaml:"id"`
+
+	// bcrypt hash of the client secret, see "kingdom-auth clients secret".
+	SecretHash string `yaml:"secret_hash"`
+
+	// Audiences the client may request tokens for. Tokens are issued for all of them if the client doesn't ask for specific ones.
+	Audiences []string `yaml:"audiences"`
+
+	// Scopes the client may request. Tokens get all of them if the client doesn't ask for specific ones.
+	Scopes []string `yaml:"scopes"`
+}
+
+// ExchangeAudienceConfig allows exchanging auth tokens for tokens of an audience (see POST /token/exchange).
+type ExchangeAudienceConfig struct {
+	Audience string `yaml:"audience"`
+
+	// Scopes that may be requested for the audience. A user only gets the ones that are also among their permissions.
+	// Tokens get all of those if the request doesn't ask for specific ones.
+	Scopes []string `yaml:"scopes"`
+
+	// Only users that list the audience in the "audiences" key of their private userdata may get tokens for it.
+	//
+	// Default: false
+	Restricted bool `yaml:"restricted"`
+}
+
+type KeyConfig struct {
+	// Path to the private key. Optional: keys without a private key can verify tokens, but never sign them.
+	PrivateKeyPath string `yaml:"private_key_path"`
+
+	// Path to the public key. Optional if a private key is set.
+	PublicKeyPath string `yaml:"public_key_path"`
+
+	// Signing algorithm, see Token.Algorithm.
+	Algorithm string `yaml:"algorithm"`
 }
 
 type SystemTokenConfig struct {
@@ -53,36 +170,139 @@ type Config struct {
 
 	OAuthProviders []OAuthConfig `yaml:"providers"`
 
+	Auth struct {
+		// Time to live for the state of a login flow (in seconds). A login has to be completed at the provider within this time.
+		// The state is bound to the browser that started the login with a short-lived, signed cookie.
+		//
+		// Default: 600 (10 minutes)
+		StateTTL uint `yaml:"state_ttl" env:"AUTH_STATE_TTL" env-default:"600"`
+
+		// URLs the browser may be sent to after a login (via /auth/begin/:provider?redirect_uri=...&error_uri=...).
+		// Entries are matched against scheme, host and path. The host may start with "*." to allow all subdomains,
+		// the path may end with "*" to allow everything below it. Example: "https://*.example.com/app/*"
+		//
+		// default: empty (no redirects, the login ends with a page that closes the window)
+		RedirectAllowList []string `yaml:"redirect_allow_list" env:"AUTH_REDIRECT_ALLOW_LIST"`
+	} `yaml:"auth"`
+
+	// OAuth 2.0 Device Authorization Grant (RFC 8628) for clients without a browser, like CLIs.
+	Device struct {
+		// default: false
+		Enabled bool `yaml:"enabled" env:"DEVICE_ENABLED" env-default:"false"`
+
+		// Time to live for a device code (in seconds). The user has to complete the login within this time.
+		//
+		// Default: 600 (10 minutes)
+		CodeTTL uint `yaml:"code_ttl" env:"DEVICE_CODE_TTL" env-default:"600"`
+
+		// Minimum time between two polls of a device (in seconds).
+		//
+		// Default: 5
+		Interval uint `yaml:"interval" env:"DEVICE_INTERVAL" env-default:"5"`
+	} `yaml:"device"`
+
 	Token struct {
 		// DEPRECATED: Use PrivateKeyPath instead for RSA signing
 		KeyPhrase string `yaml:"key_phrase" env:"KEY_Phrase"`
 
-		// Path to RSA private key file for RS512 signing
+		// Path to the private key file used for signing (RSA, ECDSA or Ed25519)
 		PrivateKeyPath string `yaml:"private_key_path" env:"PRIVATE_KEY_PATH" env-default:"private_key.pem"`
 
-		// Path to RSA public key file for RS512 verification
+		// Path to the public key file used for verification
 		PublicKeyPath string `yaml:"public_key_path" env:"PUBLIC_KEY_PATH" env-default:"public_key.pem"`
 
+		// Signing algorithm. ECDSA and Ed25519 keys determine their algorithm themselves (P-256: ES256, P-384: ES384,
+		// P-521: ES512, Ed25519: EdDSA), RSA keys can use RS256, RS384 or RS512.
+		// Only applies to the key from PrivateKeyPath - keys from KeysDir use the algorithm of the key (RS512 for RSA keys),
+		// list them in Keys to choose another one.
+		//
+		// Default: RS512 for RSA keys, otherwise determined by the key
+		Algorithm string `yaml:"algorithm" env:"JWT_ALGORITHM"`
+
+		// Directory with additional keys (every *.pem file in it). Public key files only verify tokens, a public key next to its
+		// private key (like the output of "kingdom-auth keys generate") is merged with it. Together with Keys and the key from PrivateKeyPath,
+		// they make up the keyring: one key is active and signs new tokens, all others still verify tokens until they're retired.
+		// If KeysDir or Keys is set, the key pair from PrivateKeyPath/PublicKeyPath is optional.
+		KeysDir string `yaml:"keys_dir" env:"KEYS_DIR"`
+
+		// Additional keys for the keyring, see KeysDir. Files listed here are skipped in KeysDir, so their algorithm can be set.
+		Keys []KeyConfig `yaml:"keys"`
+
+		// Key id ("kid", see /.well-known/jwks.json) of the key that signs new tokens.
+		// Promoting a key via the system service overrides this setting.
+		//
+		// Default: the key from PrivateKeyPath, or the first key that can sign
+		ActiveKey string `yaml:"active_key" env:"ACTIVE_KEY"`
+
 		// Time to live for the refresh token (in seconds). The refresh token is a long-lived cookie and bound to the core domain of the auth service.
 		// It'll be used to generate short-lived auth-tokens that can be used across your service.
 		// Default: 864000 (10 days)
 		RefreshTokenTTL uint `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL" env-default:"864000"`
 
-		// minimum age of a refresh token before a new one is sent back with any request.
+		// minimum age of a refresh token (in seconds) before it is rotated: a new one is sent back with the next request to /token,
+		// the old one is invalidated. Presenting an already rotated refresh token again revokes all refresh tokens of that login.
+		// Has to be below RefreshTokenTTL, the refresh cookie lives as long as the refresh token.
 		//
 		// Default: 86400 (one day)
-		MinAgeForRefresh uint `yaml:"refresh_token_min_age" env:"REFRESH_TOKEN_TTL" env-default:"86400"`
+		MinAgeForRefresh uint `yaml:"refresh_token_min_age" env:"REFRESH_TOKEN_MIN_AGE" env-default:"86400"`
+
+		// time (in seconds) a rotated refresh token is still accepted - without rotating it again - instead of counting as reuse.
+		// Covers concurrent refreshes, e.g. of two tabs that send the same cookie before either got the new one.
+		//
+		// Default: 10
+		ReuseGrace uint `yaml:"refresh_token_reuse_grace" env:"REFRESH_TOKEN_REUSE_GRACE" env-default:"10"`
 
 		// Time to live for the auth token (in seconds). Should be very small (1-2 minutes is good).
 		//
 		// Default: 90 (1.5 minutes)
 		AuthTokenTTL uint `yaml:"auth_token_ttl" env:"AUTH_TOKEN_TTL" env-default:"90"`
 
+		// Only accept refresh tokens from the cookie. Disables POST /token, which reads the refresh token from the request
+		// body or Authorization header for clients that can't use cookies (mobile apps, server-side rendering, CLIs).
+		//
+		// Default: false
+		CookieOnly bool `yaml:"cookie_only" env:"TOKEN_COOKIE_ONLY" env-default:"false"`
+
 		Issuer string `yaml:"issuer" env:"JWT_ISSUER" env-default:"kingdom-auth"`
 
 		DefaultAudience string `yaml:"default_audience" env:"JWT_DEFAULT_AUDIENCE" env-default:"default-audience"`
 	} `yaml:"token"`
 
+	// kingdom-auth as OpenID Connect provider for other applications.
+	OIDC struct {
+		Clients []ClientConfig `yaml:"clients"`
+
+		// Time to live for authorization codes (in seconds).
+		//
+		// Default: 60
+		CodeTTL uint `yaml:"code_ttl" env:"OIDC_CODE_TTL" env-default:"60"`
+
+		// Time to live for access and ID tokens issued to clients (in seconds).
+		//
+		// Default: 3600 (1 hour)
+		TokenTTL uint `yaml:"token_ttl" env:"OIDC_TOKEN_TTL" env-default:"3600"`
+	} `yaml:"oidc"`
+
+	// Backends that call each other with tokens of kingdom-auth (client credentials grant).
+	ServiceClients struct {
+		Clients []ServiceClientConfig `yaml:"clients"`
+
+		// Time to live for tokens issued to service clients (in seconds).
+		//
+		// Default: 300 (5 minutes)
+		TokenTTL uint `yaml:"token_ttl" env:"SERVICE_TOKEN_TTL" env-default:"300"`
+	} `yaml:"service_clients"`
+
+	// Exchanging auth tokens for tokens with a narrower audience and scope (RFC 8693).
+	TokenExchange struct {
+		Audiences []ExchangeAudienceConfig `yaml:"audiences"`
+
+		// Time to live for exchanged tokens (in seconds). They never outlive the token they were exchanged for.
+		//
+		// Default: 90 (1.5 minutes)
+		TokenTTL uint `yaml:"token_ttl" env:"EXCHANGE_TOKEN_TTL" env-default:"90"`
+	} `yaml:"token_exchange"`
+
 	MainService struct {
 		Port      int    `yaml:"port" env:"MAIN_PORT" env-default:"14414"`
 		PublicUrl string `yaml:"public_url" env:"MAIN_PUBLIC_URL" env-default:"http://localhost:14414"`
@@ -3,4 +3,28 @@ package core
 const (
 	KingdomAuthVersion      = "1"
 	KingdomAuthVersionClaim = "kaver"
+
+	RolesClaim       = "roles"
+	PermissionsClaim = "permissions"
+
+	// what a token is for. Only auth tokens (exchanged ones too) and tokens of service clients are meant for internal services.
+	TokenUseClaim      = "token_use"
+	TokenUseAuth       = "auth"
+	TokenUseRefresh    = "refresh"
+	TokenUseService    = "service"
+	TokenUseOIDCAccess = "oidc_access"
+	TokenUseOIDCID     = "oidc_id"
+
+	// tokens of service clients carry SubjectTypeClient in SubjectTypeClaim, their subject is the client id
+	SubjectTypeClaim  = "sub_type"
+	SubjectTypeClient = "client"
+	ClientIDClaim     = "client_id"
+	ScopeClaim        = "scope"
 )
+
+// IsAccessToken reports whether the claims belong to a token internal services may accept: an auth token or a token of a
+// service client. Refresh tokens and tokens of OpenID Connect clients are no access tokens for internal services.
+func IsAccessToken(claims map[string]any) bool {
+	use := claims[TokenUseClaim]
+	return use == TokenUseAuth || use == TokenUseService
+}
@@ -8,3 +8,6 @@ var ErrTokenInvalid = errors.New("invalid token")
 var ErrTokenExpired = errors.New("token expired")
 var ErrFailedToParseToken = errors.New("failed to parse token")
 var ErrInvalidSignature = errors.New("invalid signature")
+var ErrSessionReused = errors.New("session was already rotated")
+var ErrLastAuthentication = errors.New("cannot remove the last authentication of a user")
+var ErrDeviceCodeExpired = errors.New("device code expired")
@@ -20,3 +20,23 @@ type User struct {
 
 	Email string `json:"email"`
 }
+
+type Session struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRole struct {
+	Role   string `json:"role"`
+	Source string `json:"source"`
+}
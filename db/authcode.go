@@ -0,0 +1,70 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthorizationCode is issued by /authorize and redeemed once at /oauth/token. Only the hash of the code is stored.
+type AuthorizationCode struct {
+	gorm.Model
+
+	CodeHash    string `gorm:"uniqueIndex;size:64"`
+	ClientID    string
+	UserID      uint
+	RedirectURI string
+	Scope       string
+	Nonce       string
+
+	// PKCE (S256), empty if the client didn't use it
+	CodeChallenge string
+
+	ExpiresAt time.Time
+}
+
+func (d *Driver) CreateAuthorizationCode(code *AuthorizationCode) error {
+	return d.db.Create(code).Error
+}
+
+// RedeemAuthorizationCode looks up the code with the given hash and deletes it, if check accepts it. Returns
+// gorm.ErrRecordNotFound if there is no such code (or it was redeemed already), so a code can only be used once, and the
+// error of check if it rejected the code - the code is kept then. Expiry is up to check.
+func (d *Driver) RedeemAuthorizationCode(codeHash string, check func(code *AuthorizationCode) error) (*AuthorizationCode, error) {
+	code := AuthorizationCode{}
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&code, "code_hash = ?", codeHash).Error
+		if err != nil {
+			return err
+		}
+
+		err = check(&code)
+		if err != nil {
+			return err
+		}
+
+		res := tx.Unscoped().Delete(&AuthorizationCode{}, code.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredAuthorizationCodes removes authorization codes that expired before the given time.
+func (d *Driver) DeleteExpiredAuthorizationCodes(before time.Time) (int64, error) {
+	res := d.db.Unscoped().Where("expires_at < ?", before).Delete(&AuthorizationCode{})
+	return res.RowsAffected, res.Error
+}
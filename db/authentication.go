@@ -12,5 +12,6 @@ type Authentication struct {
 	Provider string
 	Subject  string
 
-	Email string
+	Email         string
+	EmailVerified bool
 }
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"gorm.io/gorm"
+)
+
+// DeviceCode is a pending device authorization (RFC 8628). The device polls with the device code (stored hashed only),
+// the user approves it in a browser by entering the user code and logging in.
+type DeviceCode struct {
+	gorm.Model
+
+	DeviceCodeHash string `gorm:"uniqueIndex;size:64"`
+	UserCode       string `gorm:"uniqueIndex;size:16"`
+	ExpiresAt      time.Time
+	LastPolledAt   *time.Time
+
+	// set once a user approved the device
+	UserID *uint
+	Denied bool
+}
+
+// IsPending reports whether the device code still waits for a user.
+func (d *DeviceCode) IsPending() bool {
+	return d.UserID == nil && !d.Denied && d.ExpiresAt.After(time.Now())
+}
+
+func (d *Driver) CreateDeviceCode(code *DeviceCode) error {
+	return d.db.Create(code).Error
+}
+
+func (d *Driver) GetDeviceCode(deviceCodeHash string) (*DeviceCode, error) {
+	code := DeviceCode{}
+	return &code, d.db.First(&code, "device_code_hash = ?", deviceCodeHash).Error
+}
+
+func (d *Driver) GetDeviceCodeByUserCode(userCode string) (*DeviceCode, error) {
+	code := DeviceCode{}
+	return &code, d.db.First(&code, "user_code = ?", userCode).Error
+}
+
+// ApproveDeviceCode hands a pending device code to a user. Returns gorm.ErrRecordNotFound if there is no such pending code.
+func (d *Driver) ApproveDeviceCode(userCode string, userID uint) error {
+	res := d.db.Model(&DeviceCode{}).
+		Where("user_code = ? AND user_id IS NULL AND denied = ? AND expires_at > ?", userCode, false, time.Now()).
+		Update("user_id", userID)
+
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DenyDeviceCode marks a pending device code as denied.
+func (d *Driver) DenyDeviceCode(userCode string) error {
+	return d.db.Model(&DeviceCode{}).
+		Where("user_code = ? AND user_id IS NULL", userCode).
+		Update("denied", true).Error
+}
+
+// PollDeviceCode records a poll of the device. Returns the time of the previous poll.
+func (d *Driver) PollDeviceCode(code *DeviceCode) (*time.Time, error) {
+	previous := code.LastPolledAt
+	now := time.Now()
+
+	err := d.db.Model(code).Update("last_polled_at", now).Error
+
+	return previous, err
+}
+
+// ConsumeDeviceCode deletes an unexpired device code once its tokens are issued. Returns gorm.ErrRecordNotFound if it was
+// consumed already, so a code can't be redeemed twice, and core.ErrDeviceCodeExpired if it expired.
+func (d *Driver) ConsumeDeviceCode(code *DeviceCode) error {
+	res := d.db.Unscoped().Where("expires_at > ?", time.Now()).Delete(&DeviceCode{}, code.ID)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		if !code.ExpiresAt.After(time.Now()) {
+			return core.ErrDeviceCodeExpired
+		}
+
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpiredDeviceCodes removes device codes that expired before the given time.
+func (d *Driver) DeleteExpiredDeviceCodes(before time.Time) (int64, error) {
+	res := d.db.Unscoped().Where("expires_at < ?", before).Delete(&DeviceCode{})
+	return res.RowsAffected, res.Error
+}
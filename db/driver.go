@@ -55,6 +55,31 @@ func (d *Driver) migrate() error {
 		return err
 	}
 
+	err = d.db.AutoMigrate(&Session{})
+	if err != nil {
+		return err
+	}
+
+	err = d.db.AutoMigrate(&KeyState{})
+	if err != nil {
+		return err
+	}
+
+	err = d.db.AutoMigrate(&Role{}, &Permission{}, &UserRole{})
+	if err != nil {
+		return err
+	}
+
+	err = d.db.AutoMigrate(&DeviceCode{})
+	if err != nil {
+		return err
+	}
+
+	err = d.db.AutoMigrate(&AuthorizationCode{})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -82,11 +107,75 @@ func (d *Driver) GetUser(id uint32) (*User, error) {
 	return &user, d.db.Preload("Authentications").First(&user, id).Error
 }
 
+// ListUsers returns up to limit users whose ID is greater than after, ordered by ID.
+// Pass the ID of the last user of a page as after to get the next page.
+func (d *Driver) ListUsers(after uint, limit int) ([]User, error) {
+	users := make([]User, 0)
+	return users, d.db.Preload("Authentications").Where("id > ?", after).Order("id").Limit(limit).Find(&users).Error
+}
+
+// DeleteUser deletes a user and all of its authentications, sessions and role assignments, and its pending device and
+// authorization codes. A soft deletion keeps the rows (marked as deleted) in the database, a hard deletion removes them for good.
+func (d *Driver) DeleteUser(id uint32, hard bool) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if hard {
+			// a new session, otherwise the conditions of the statements below would pile up
+			tx = tx.Unscoped().Session(&gorm.Session{})
+		}
+
+		user := User{}
+		err := tx.First(&user, id).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", user.ID).Delete(&Authentication{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", user.ID).Delete(&Session{}).Error
+		if err != nil {
+			return err
+		}
+
+		// role assignments aren't soft-deletable
+		err = tx.Where("user_id = ?", user.ID).Delete(&UserRole{}).Error
+		if err != nil {
+			return err
+		}
+
+		// pending codes could still mint tokens for the user - they are one-shot, so they're always gone for good
+		err = tx.Unscoped().Where("user_id = ?", user.ID).Delete(&DeviceCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("user_id = ?", user.ID).Delete(&AuthorizationCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+}
+
 func (d *Driver) TryGetAuthentication(provider string, subject string) (*Authentication, error) {
 	auth := Authentication{}
 	return &auth, d.db.First(&auth, "provider = ? AND subject = ?", provider, subject).Error
 }
 
+// FindVerifiedAuthentications returns the authentications with the given verified email (ignoring case) from the given providers.
+func (d *Driver) FindVerifiedAuthentications(email string, providers []string) ([]Authentication, error) {
+	auths := make([]Authentication, 0)
+
+	if email == "" || len(providers) == 0 {
+		return auths, nil
+	}
+
+	return auths, d.db.Where("LOWER(email) = LOWER(?) AND email_verified = ? AND provider IN ?", email, true, providers).Order("id").Find(&auths).Error
+}
+
 func (d *Driver) GetUserFor(auth *Authentication) (*User, error) {
 	user := User{}
 	return &user, d.db.Preload("Authentications").First(&user, "id = ?", auth.UserID).Error
@@ -109,3 +198,120 @@ func (d *Driver) CreateAuthenticationFor(user *User) (*Authentication, error) {
 func (d *Driver) UpdateAuthentication(auth *Authentication) error {
 	return d.db.Save(auth).Error
 }
+
+// DeleteAuthentications removes the authentications of a user with the given provider, and - if not empty - subject.
+// Returns gorm.ErrRecordNotFound if none match and core.ErrLastAuthentication if the user would be left without any.
+func (d *Driver) DeleteAuthentications(userID uint, provider string, subject string) (int64, error) {
+	var deleted int64
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		matching := tx.Model(&Authentication{}).Where("user_id = ? AND provider = ?", userID, provider)
+		if subject != "" {
+			matching = matching.Where("subject = ?", subject)
+		}
+
+		var total, count int64
+
+		err := tx.Model(&Authentication{}).Where("user_id = ?", userID).Count(&total).Error
+		if err != nil {
+			return err
+		}
+
+		err = matching.Session(&gorm.Session{}).Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if total-count < 1 {
+			return core.ErrLastAuthentication
+		}
+
+		// deleted for good, so the identity can be linked again or used to sign up
+		res := matching.Unscoped().Delete(&Authentication{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+
+	return deleted, err
+}
+
+// CreateSession creates a new session with a random jti for a user. The session starts a new family.
+func (d *Driver) CreateSession(user *User, expiresAt time.Time) (*Session, error) {
+	familyID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return createSession(d.db, user.ID, familyID, expiresAt)
+}
+
+// RotateSession replaces a session with a new one of the same family.
+// Returns core.ErrSessionReused if the session was already rotated (possibly concurrently).
+func (d *Driver) RotateSession(old *Session, expiresAt time.Time) (*Session, error) {
+	var session *Session
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Session{}).Where("id = ? AND rotated_at IS NULL", old.ID).Update("rotated_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected != 1 {
+			return core.ErrSessionReused
+		}
+
+		var err error
+		session, err = createSession(tx, old.UserID, old.FamilyID, expiresAt)
+		return err
+	})
+
+	return session, err
+}
+
+func createSession(tx *gorm.DB, userID uint, familyID string, expiresAt time.Time) (*Session, error) {
+	jti, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	session := Session{
+		UserID:    userID,
+		JTI:       jti,
+		FamilyID:  familyID,
+		ExpiresAt: expiresAt,
+	}
+
+	return &session, tx.Create(&session).Error
+}
+
+func (d *Driver) GetSession(jti string) (*Session, error) {
+	session := Session{}
+	return &session, d.db.First(&session, "jti = ?", jti).Error
+}
+
+func (d *Driver) ListSessionsFor(userID uint) ([]Session, error) {
+	sessions := make([]Session, 0)
+	return sessions, d.db.Where("user_id = ?", userID).Order("id").Find(&sessions).Error
+}
+
+// RevokeFamily revokes all sessions of a family and returns how many were revoked.
+func (d *Driver) RevokeFamily(familyID string) (int64, error) {
+	res := d.db.Model(&Session{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+// RevokeSessionsFor revokes all sessions of a user and returns how many were revoked.
+func (d *Driver) RevokeSessionsFor(userID uint) (int64, error) {
+	res := d.db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+// DeleteExpiredSessions removes sessions that expired before the given time for good.
+func (d *Driver) DeleteExpiredSessions(before time.Time) (int64, error) {
+	res := d.db.Unscoped().Where("expires_at < ?", before).Delete(&Session{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"gorm.io/gorm"
+)
+
+func newTestDriver(t *testing.T) *Driver {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Db.Type = "sqlite"
+	cfg.Db.DSN = filepath.Join(t.TempDir(), "kingdom-auth.db")
+	cfg.Db.RunMigrations = true
+
+	d, err := NewDriver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestDeleteUserRemovesPendingCodes(t *testing.T) {
+	for _, hard := range []bool{false, true} {
+		d := newTestDriver(t)
+
+		user, err := d.CreateUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expiresAt := time.Now().Add(time.Minute)
+
+		err = d.CreateDeviceCode(&DeviceCode{DeviceCodeHash: "device", UserCode: "BCDF-GHJK", ExpiresAt: expiresAt, UserID: &user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = d.CreateAuthorizationCode(&AuthorizationCode{CodeHash: "code", ClientID: "wiki", UserID: user.ID, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = d.DeleteUser(uint32(user.ID), hard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := d.GetUser(uint32(user.ID)); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("hard=%v: user still exists (error = %v)", hard, err)
+		}
+
+		if _, err := d.GetDeviceCode("device"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("hard=%v: device code still exists (error = %v)", hard, err)
+		}
+
+		if _, err := d.RedeemAuthorizationCode("code", func(*AuthorizationCode) error { return nil }); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("hard=%v: authorization code still exists (error = %v)", hard, err)
+		}
+	}
+}
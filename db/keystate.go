@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KeyState persists changes made to the keyring at runtime (promoting and retiring keys), so they survive restarts.
+type KeyState struct {
+	Kid       string `gorm:"primaryKey;size:64"`
+	Active    bool
+	Retired   bool
+	UpdatedAt time.Time
+}
+
+func (d *Driver) GetKeyStates() ([]KeyState, error) {
+	states := make([]KeyState, 0)
+	return states, d.db.Find(&states).Error
+}
+
+// SetActiveKey marks the key with the given id as the only active key.
+func (d *Driver) SetActiveKey(kid string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&KeyState{}).Where("active = ?", true).Update("active", false).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "kid"}},
+			DoUpdates: clause.AssignmentColumns([]string{"active", "updated_at"}),
+		}).Create(&KeyState{Kid: kid, Active: true}).Error
+	})
+}
+
+// SetKeyRetired marks the key with the given id as retired.
+func (d *Driver) SetKeyRetired(kid string) error {
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"retired", "updated_at"}),
+	}).Create(&KeyState{Kid: kid, Retired: true}).Error
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// randomID returns 32 random hex characters (128 bits).
+func randomID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
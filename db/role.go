@@ -0,0 +1,196 @@
+package db
+
+import (
+	"slices"
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RoleSourceManual marks role assignments made through the system service.
+const RoleSourceManual = "manual"
+
+// Role is a named set of permissions that can be assigned to users.
+type Role struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Name        string `gorm:"uniqueIndex;size:128"`
+	Description string
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+}
+
+type Permission struct {
+	ID   uint   `gorm:"primarykey"`
+	Name string `gorm:"uniqueIndex;size:128"`
+}
+
+// UserRole assigns a role to a user. Source records who made the assignment.
+type UserRole struct {
+	UserID    uint `gorm:"primaryKey"`
+	RoleID    uint `gorm:"primaryKey;index"`
+	Source    string
+	CreatedAt time.Time
+
+	Role Role
+}
+
+// ToCore converts the database model into the shape handed out by the APIs.
+func (r *Role) ToCore() core.Role {
+	role := core.Role{
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: make([]string, 0, len(r.Permissions)),
+	}
+
+	for _, p := range r.Permissions {
+		role.Permissions = append(role.Permissions, p.Name)
+	}
+
+	return role
+}
+
+func (d *Driver) ListRoles() ([]Role, error) {
+	roles := make([]Role, 0)
+	return roles, d.db.Preload("Permissions").Order("name").Find(&roles).Error
+}
+
+func (d *Driver) GetRole(name string) (*Role, error) {
+	role := Role{}
+	return &role, d.db.Preload("Permissions").First(&role, "name = ?", name).Error
+}
+
+// SaveRole creates or updates a role. The permissions of the role are replaced.
+func (d *Driver) SaveRole(name string, description string, permissions []string) (*Role, error) {
+	role := Role{}
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(Role{Name: name}).FirstOrCreate(&role).Error
+		if err != nil {
+			return err
+		}
+
+		role.Description = description
+
+		err = tx.Save(&role).Error
+		if err != nil {
+			return err
+		}
+
+		perms := make([]Permission, 0, len(permissions))
+		for _, p := range permissions {
+			perm := Permission{}
+
+			err = tx.Where(Permission{Name: p}).FirstOrCreate(&perm).Error
+			if err != nil {
+				return err
+			}
+
+			perms = append(perms, perm)
+		}
+
+		return tx.Model(&role).Association("Permissions").Replace(perms)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// DeleteRole deletes a role and all of its assignments.
+func (d *Driver) DeleteRole(name string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		role := Role{}
+
+		err := tx.First(&role, "name = ?", name).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("role_id = ?", role.ID).Delete(&UserRole{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&role).Association("Permissions").Clear()
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&role).Error
+	})
+}
+
+// GetRolesFor returns the role assignments of a user, including the roles and their permissions.
+func (d *Driver) GetRolesFor(userID uint) ([]UserRole, error) {
+	roles := make([]UserRole, 0)
+	return roles, d.db.Preload("Role.Permissions").Where("user_id = ?", userID).Find(&roles).Error
+}
+
+// AssignRole assigns a role to a user. Assigning a role the user already has only updates the source.
+// Returns gorm.ErrRecordNotFound if the role doesn't exist.
+func (d *Driver) AssignRole(userID uint, roleName string, source string) error {
+	role := Role{}
+
+	err := d.db.First(&role, "name = ?", roleName).Error
+	if err != nil {
+		return err
+	}
+
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source"}),
+	}).Create(&UserRole{UserID: userID, RoleID: role.ID, Source: source}).Error
+}
+
+// RemoveRole removes a role from a user. Returns gorm.ErrRecordNotFound if the user doesn't have the role.
+func (d *Driver) RemoveRole(userID uint, roleName string) error {
+	role := Role{}
+
+	err := d.db.First(&role, "name = ?", roleName).Error
+	if err != nil {
+		return err
+	}
+
+	res := d.db.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&UserRole{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RolesAndPermissions returns the names of the roles of a user and the permissions they grant, both sorted and without duplicates.
+func (d *Driver) RolesAndPermissions(userID uint) ([]string, []string, error) {
+	assignments, err := d.GetRolesFor(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roles := make([]string, 0, len(assignments))
+	permissions := make([]string, 0)
+
+	for _, a := range assignments {
+		roles = append(roles, a.Role.Name)
+
+		for _, p := range a.Role.Permissions {
+			permissions = append(permissions, p.Name)
+		}
+	}
+
+	return sortedUnique(roles), sortedUnique(permissions), nil
+}
+
+func sortedUnique(list []string) []string {
+	slices.Sort(list)
+	return slices.Compact(list)
+}
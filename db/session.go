@@ -0,0 +1,41 @@
+package db
+
+import (
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"gorm.io/gorm"
+)
+
+// Session is the server-side record of a refresh token, referenced by the token's jti claim.
+// A refresh token is only accepted as long as its session exists, is not expired, not revoked and not rotated.
+//
+// Every rotation replaces a session with a new one of the same family. All sessions created from one login share a family.
+type Session struct {
+	gorm.Model
+
+	UserID uint `gorm:"index"`
+
+	JTI       string `gorm:"uniqueIndex;size:64"`
+	FamilyID  string `gorm:"index;size:64"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	RotatedAt *time.Time
+}
+
+// IsActive reports whether refresh tokens of this session may still be used.
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && s.RotatedAt == nil && s.ExpiresAt.After(time.Now())
+}
+
+// ToCore converts the database model into the shape handed out by the APIs.
+func (s *Session) ToCore() core.Session {
+	return core.Session{
+		ID:        s.JTI,
+		FamilyID:  s.FamilyID,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+		RevokedAt: s.RevokedAt,
+		RotatedAt: s.RotatedAt,
+	}
+}
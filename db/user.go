@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/5000K/kingdom-auth/core"
 	"gorm.io/gorm"
 )
 
@@ -20,6 +21,49 @@ type User struct {
 	LastLogin       time.Time
 }
 
+// HasLoggedIn reports whether the user ever finished a login. New users start with LastLogin at the unix epoch.
+func (u *User) HasLoggedIn() bool {
+	return u.LastLogin.After(time.UnixMilli(0))
+}
+
+// ToCore converts the database model into the shape handed out by the APIs.
+func (u *User) ToCore() (*core.User, error) {
+	pub, err := u.GetPublicUserdata()
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := u.GetPrivateUserdata()
+	if err != nil {
+		return nil, err
+	}
+
+	pubMap := map[string]any(pub)
+	privMap := map[string]any(priv)
+
+	user := &core.User{
+		ID:              u.ID,
+		PublicData:      &pubMap,
+		PrivateData:     &privMap,
+		LastLogin:       u.LastLogin,
+		Authentications: make([]core.Authentication, 0, len(u.Authentications)),
+	}
+
+	for _, auth := range u.Authentications {
+		user.Authentications = append(user.Authentications, core.Authentication{
+			Provider: auth.Provider,
+			Subject:  auth.Subject,
+			Email:    auth.Email,
+		})
+
+		if user.Email == "" && auth.Email != "" {
+			user.Email = auth.Email
+		}
+	}
+
+	return user, nil
+}
+
 func (u *User) GetPublicUserdata() (UserData, error) {
 	ud := UserData{}
 	return ud, ud.Scan(u.PublicData)
@@ -52,6 +96,36 @@ func (u *User) SetPrivateUserdata(ud UserData) error {
 	return nil
 }
 
+// MergePatch applies a JSON merge patch (RFC 7386) to the userdata and returns the result.
+// Keys set to null in the patch are removed, nested objects are merged recursively and everything else is replaced.
+func (v UserData) MergePatch(patch map[string]any) UserData {
+	return UserData(mergePatch(map[string]any(v), patch))
+}
+
+func mergePatch(target map[string]any, patch map[string]any) map[string]any {
+	if target == nil {
+		target = make(map[string]any)
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		patchObject, ok := value.(map[string]any)
+		if !ok {
+			target[key] = value
+			continue
+		}
+
+		targetObject, _ := target[key].(map[string]any)
+		target[key] = mergePatch(targetObject, patchObject)
+	}
+
+	return target
+}
+
 func (v *UserData) Scan(value interface{}) error {
 	str, ok := value.(string)
 
@@ -36,4 +36,13 @@ func main() {
 	if publicData, ok := claims["public-data"]; ok {
 		fmt.Printf("Public Data: %v\n", publicData)
 	}
+
+	// roles and permissions are managed through the system service
+	if client.HasPermission(claims, "billing:write") {
+		fmt.Println("User may write billing data")
+	}
+
+	if client.HasRole(claims, "admin") {
+		fmt.Println("User is an admin")
+	}
 }
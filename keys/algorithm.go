@@ -0,0 +1,71 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RSA keys can sign with any of these, the default is RS512 (the algorithm kingdom-auth always used)
+var rsaMethods = []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodRS384, jwt.SigningMethodRS512}
+
+// Methods lists all signing methods kingdom-auth supports.
+var Methods = []jwt.SigningMethod{
+	jwt.SigningMethodRS256,
+	jwt.SigningMethodRS384,
+	jwt.SigningMethodRS512,
+	jwt.SigningMethodES256,
+	jwt.SigningMethodES384,
+	jwt.SigningMethodES512,
+	jwt.SigningMethodEdDSA,
+}
+
+// MethodFor returns the signing method to use with a public key. ECDSA and Ed25519 keys determine their algorithm,
+// for RSA keys it can be chosen with alg (default: RS512). If alg is set, it has to fit the key.
+func MethodFor(pub crypto.PublicKey, alg string) (jwt.SigningMethod, error) {
+	var method jwt.SigningMethod
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg == "" {
+			return jwt.SigningMethodRS512, nil
+		}
+
+		i := slices.IndexFunc(rsaMethods, func(m jwt.SigningMethod) bool { return m.Alg() == alg })
+		if i < 0 {
+			return nil, fmt.Errorf("algorithm %q can't be used with an RSA key (supported: RS256, RS384, RS512)", alg)
+		}
+
+		return rsaMethods[i], nil
+
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	if alg != "" && alg != method.Alg() {
+		return nil, fmt.Errorf("algorithm %q can't be used with this key, it requires %s", alg, method.Alg())
+	}
+
+	return method, nil
+}
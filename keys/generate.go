@@ -0,0 +1,73 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// Generate creates a new private key for the given algorithm (RS256, RS384, RS512, ES256, ES384, ES512 or EdDSA,
+// case-insensitive). rsaBits is only used for RSA keys.
+func Generate(alg string, rsaBits int) (crypto.Signer, error) {
+	switch strings.ToUpper(alg) {
+	case "RS256", "RS384", "RS512":
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EDDSA", "ED25519":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q (supported: RS256, RS384, RS512, ES256, ES384, ES512, EdDSA)", alg)
+	}
+}
+
+// EncodePrivateKey encodes a private key as PEM (PKCS8).
+func EncodePrivateKey(private crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// EncodePublicKey encodes a public key as PEM (PKIX).
+func EncodePublicKey(public crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the DER encoded public key, as colon separated hex.
+func Fingerprint(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = hex.EncodeToString([]byte{b})
+	}
+
+	return strings.Join(parts, ":"), nil
+}
@@ -0,0 +1,193 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served by /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// PublicJWK returns the JWK of a public key used for signing with the given algorithm. The kid is the key's thumbprint.
+func PublicJWK(pub crypto.PublicKey, alg string) (JWK, error) {
+	jwk, err := publicMembers(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	jwk.Kid, err = Thumbprint(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	jwk.Use = "sig"
+	jwk.Alg = alg
+
+	return jwk, nil
+}
+
+// publicMembers returns a JWK with only the key type specific members set.
+func publicMembers(pub crypto.PublicKey) (JWK, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   b64.EncodeToString(k.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve (RFC 7518, section 6.2.1.2)
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   b64.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   b64.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64.EncodeToString(k),
+		}, nil
+
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638) of a public key. It is used as stable key id ("kid").
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicMembers(pub)
+	if err != nil {
+		return "", err
+	}
+
+	// RFC 7638 requires the required members in lexicographic order and without whitespace, which is exactly what
+	// encoding/json produces for a map.
+	members := map[string]string{
+		"kty": jwk.Kty,
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		members["e"] = jwk.E
+		members["n"] = jwk.N
+	case "EC":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+		members["y"] = jwk.Y
+	case "OKP":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return b64.EncodeToString(sum[:]), nil
+}
+
+// PublicKey returns the public key described by the JWK.
+func (j *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+
+		e, err := b64.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+
+		y, err := b64.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
@@ -0,0 +1,62 @@
+package keys
+
+import (
+	"testing"
+)
+
+// example of RFC 7638, section 3.1
+func TestThumbprintRFC7638(t *testing.T) {
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Thumbprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint = %s, want %s", got, want)
+	}
+}
+
+// the thumbprint only depends on the public key, not on the way it was loaded
+func TestThumbprintRoundTrip(t *testing.T) {
+	for _, alg := range []string{"rs512", "es256", "es384", "es512", "eddsa"} {
+		private, err := Generate(alg, 2048)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		jwk, err := PublicJWK(private.Public(), "")
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		parsed, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		want, err := Thumbprint(private.Public())
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		got, err := Thumbprint(parsed)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		if got != want || len(got) != 43 {
+			t.Errorf("%s: thumbprint of the parsed JWK = %s, want %s", alg, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadPrivateKey reads a PEM encoded private key from a file. See ParsePrivateKey for the supported formats.
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA (PKCS1), ECDSA (SEC1) or any supported key in PKCS8 format.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing private key")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	if privateKey, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	keyInterface, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	switch k := keyInterface.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return k.(crypto.Signer), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", keyInterface)
+	}
+}
+
+// LoadPublicKey reads a PEM encoded public key (PKIX) from a file.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	return ParsePublicKey(data)
+}
+
+// ParsePublicKey parses a PEM encoded RSA, ECDSA or Ed25519 public key (PKIX).
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+
+	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	switch publicKeyInterface.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKeyInterface, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKeyInterface)
+	}
+}
+
+// isPublicKeyFile reports whether a PEM file holds a public key.
+func isPublicKeyFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	return block.Type == "PUBLIC KEY", nil
+}
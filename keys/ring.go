@@ -0,0 +1,416 @@
+package keys
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown key")
+	ErrKeyCannotSign    = errors.New("key has no private key and can't sign")
+	ErrKeyRetired       = errors.New("key is retired")
+	ErrKeyActive        = errors.New("the active key can't be retired")
+	ErrNoSigningKey     = errors.New("no key in the keyring can sign")
+	ErrUnexpectedMethod = errors.New("unexpected signing method")
+)
+
+// Key is a key of the keyring.
+type Key struct {
+	ID     string
+	Public crypto.PublicKey
+
+	// where the key was loaded from, for humans
+	Source string
+
+	private crypto.Signer
+	method  jwt.SigningMethod
+	jwk     JWK
+}
+
+// CanSign reports whether the key has a private key.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// Method returns the JWT signing method used with this key.
+func (k *Key) Method() jwt.SigningMethod {
+	return k.method
+}
+
+// JWK returns the public part of the key in JSON Web Key format.
+func (k *Key) JWK() JWK {
+	return k.jwk
+}
+
+// KeyStatus describes a key of the keyring.
+type KeyStatus struct {
+	ID      string `json:"kid"`
+	Alg     string `json:"alg"`
+	Source  string `json:"source"`
+	CanSign bool   `json:"can_sign"`
+	Active  bool   `json:"active"`
+	Retired bool   `json:"retired"`
+}
+
+// Ring holds all keys of kingdom-auth. The active key signs new tokens, every other key that is not retired still verifies them.
+// This allows rotating keys without invalidating all tokens at once: add a new key, promote it, retire the old one once
+// all tokens signed with it have expired.
+type Ring struct {
+	mu sync.RWMutex
+
+	keys    []*Key
+	active  *Key
+	retired map[string]bool
+}
+
+// NewRing creates a keyring from the given keys. The first key that can sign becomes the active key.
+func NewRing(keys ...*Key) (*Ring, error) {
+	r := &Ring{
+		retired: make(map[string]bool),
+	}
+
+	for _, k := range keys {
+		// the same key may be loaded twice, e.g. from its private and its public key file - keep the one that can sign
+		if i := slices.IndexFunc(r.keys, func(existing *Key) bool { return existing.ID == k.ID }); i >= 0 {
+			if !r.keys[i].CanSign() && k.CanSign() {
+				r.keys[i] = k
+			}
+
+			continue
+		}
+
+		r.keys = append(r.keys, k)
+
+		if r.active == nil && k.CanSign() {
+			r.active = k
+		}
+	}
+
+	if r.active == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return r, nil
+}
+
+// NewKey creates a key from a private and/or public key. At least one of them must be set.
+// alg selects the signing algorithm for RSA keys and may be empty, see MethodFor.
+func NewKey(private crypto.Signer, public crypto.PublicKey, alg string, source string) (*Key, error) {
+	if private != nil {
+		if public != nil && !Matches(private, public) {
+			return nil, fmt.Errorf("%s: public key does not belong to the private key", source)
+		}
+
+		public = private.Public()
+	}
+
+	if public == nil {
+		return nil, fmt.Errorf("%s: neither private nor public key given", source)
+	}
+
+	method, err := MethodFor(public, alg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	jwk, err := PublicJWK(public, method.Alg())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	return &Key{
+		ID:      jwk.Kid,
+		Public:  public,
+		Source:  source,
+		private: private,
+		method:  method,
+		jwk:     jwk,
+	}, nil
+}
+
+// Matches reports whether a public key belongs to a private key.
+func Matches(private crypto.Signer, public crypto.PublicKey) bool {
+	pub, ok := private.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(public)
+}
+
+// LoadRing loads all keys configured in cfg.Token.
+func LoadRing(cfg *config.Config) (*Ring, error) {
+	keys := make([]*Key, 0)
+
+	// files that are configured explicitly, with their own algorithm - KeysDir skips them
+	configured := make(map[string]bool)
+
+	// the classic key pair is only optional, if other keys are configured
+	legacyOptional := cfg.Token.KeysDir != "" || len(cfg.Token.Keys) > 0
+	_, statErr := os.Stat(cfg.Token.PrivateKeyPath)
+
+	if !legacyOptional || statErr == nil {
+		kc := config.KeyConfig{PrivateKeyPath: cfg.Token.PrivateKeyPath, PublicKeyPath: cfg.Token.PublicKeyPath, Algorithm: cfg.Token.Algorithm}
+
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+		addKeyPaths(configured, kc)
+	}
+
+	for _, kc := range cfg.Token.Keys {
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+		addKeyPaths(configured, kc)
+	}
+
+	if cfg.Token.KeysDir != "" {
+		files, err := filepath.Glob(filepath.Join(cfg.Token.KeysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if configured[absPath(f)] {
+				continue
+			}
+
+			// the algorithm is determined by the key (RS512 for RSA keys) - list a file in Keys to choose another one
+			kc := config.KeyConfig{}
+
+			// public keys (like the public_key.pem of "kingdom-auth keys generate") only verify tokens
+			public, err := isPublicKeyFile(f)
+			if err != nil {
+				return nil, err
+			}
+
+			if public {
+				kc.PublicKeyPath = f
+			} else {
+				kc.PrivateKeyPath = f
+			}
+
+			k, err := loadKey(kc)
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, k)
+		}
+	}
+
+	r, err := NewRing(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Token.ActiveKey != "" {
+		err = r.Promote(cfg.Token.ActiveKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to activate key %q: %w", cfg.Token.ActiveKey, err)
+		}
+	}
+
+	return r, nil
+}
+
+// addKeyPaths adds the files of a key to paths.
+func addKeyPaths(paths map[string]bool, kc config.KeyConfig) {
+	for _, p := range []string{kc.PrivateKeyPath, kc.PublicKeyPath} {
+		if p != "" {
+			paths[absPath(p)] = true
+		}
+	}
+}
+
+// absPath returns the absolute path of a file, or the cleaned path if it can't be determined.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	return abs
+}
+
+func loadKey(kc config.KeyConfig) (*Key, error) {
+	var private crypto.Signer
+	var public crypto.PublicKey
+	var err error
+
+	sources := make([]string, 0, 2)
+
+	if kc.PrivateKeyPath != "" {
+		private, err = LoadPrivateKey(kc.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kc.PrivateKeyPath, err)
+		}
+
+		sources = append(sources, kc.PrivateKeyPath)
+	}
+
+	if kc.PublicKeyPath != "" {
+		public, err = LoadPublicKey(kc.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kc.PublicKeyPath, err)
+		}
+
+		sources = append(sources, kc.PublicKeyPath)
+	}
+
+	return NewKey(private, public, kc.Algorithm, strings.Join(sources, ", "))
+}
+
+// Active returns the key that signs new tokens.
+func (r *Ring) Active() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.active
+}
+
+// Get returns the key with the given id, as long as it is not retired.
+func (r *Ring) Get(kid string) (*Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.retired[kid] {
+		return nil, false
+	}
+
+	for _, k := range r.keys {
+		if k.ID == kid {
+			return k, true
+		}
+	}
+
+	return nil, false
+}
+
+// Status describes every key of the keyring, including retired ones.
+func (r *Ring) Status() []KeyStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]KeyStatus, 0, len(r.keys))
+
+	for _, k := range r.keys {
+		list = append(list, KeyStatus{
+			ID:      k.ID,
+			Alg:     k.Method().Alg(),
+			Source:  k.Source,
+			CanSign: k.CanSign(),
+			Active:  k == r.active,
+			Retired: r.retired[k.ID],
+		})
+	}
+
+	return list
+}
+
+// Promote makes the key with the given id the active key.
+func (r *Ring) Promote(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.keys {
+		if k.ID != kid {
+			continue
+		}
+
+		if !k.CanSign() {
+			return ErrKeyCannotSign
+		}
+
+		if r.retired[kid] {
+			return ErrKeyRetired
+		}
+
+		r.active = k
+		return nil
+	}
+
+	return ErrUnknownKey
+}
+
+// Retire stops accepting tokens signed with the key with the given id.
+func (r *Ring) Retire(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.keys {
+		if k.ID != kid {
+			continue
+		}
+
+		if k == r.active {
+			return ErrKeyActive
+		}
+
+		r.retired[kid] = true
+		return nil
+	}
+
+	return ErrUnknownKey
+}
+
+// JWKS returns the public keys of all keys that are not retired.
+func (r *Ring) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKS{
+		Keys: make([]JWK, 0, len(r.keys)),
+	}
+
+	for _, k := range r.keys {
+		if !r.retired[k.ID] {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+	}
+
+	return set
+}
+
+// Sign signs claims with the active key and sets its id as "kid" header.
+func (r *Ring) Sign(claims jwt.Claims) (string, error) {
+	k := r.Active()
+
+	t := jwt.NewWithClaims(k.Method(), claims)
+	t.Header["kid"] = k.ID
+
+	return t.SignedString(k.private)
+}
+
+// Keyfunc looks up the key to verify a token with by its "kid" header. Tokens without kid (issued before kids were
+// introduced) are verified with the active key.
+func (r *Ring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	k := r.Active()
+
+	if kid, ok := token.Header["kid"].(string); ok {
+		var found bool
+		k, found = r.Get(kid)
+
+		if !found {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	if token.Method.Alg() != k.Method().Alg() {
+		return nil, ErrUnexpectedMethod
+	}
+
+	return k.Public, nil
+}
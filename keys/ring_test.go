@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/5000K/kingdom-auth/config"
+)
+
+func writeKeyPair(t *testing.T, dir string, alg string, privateName string, publicName string) *Key {
+	t.Helper()
+
+	private, err := Generate(alg, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePEM, err := EncodePrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicPEM, err := EncodePublicKey(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, privateName), privatePEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if publicName != "" {
+		if err = os.WriteFile(filepath.Join(dir, publicName), publicPEM, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k, err := NewKey(private, nil, alg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Method().Alg() != alg {
+		t.Fatalf("key signs with %s, want %s", k.Method().Alg(), alg)
+	}
+
+	return k
+}
+
+func TestLoadRingKeysDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// the layout of "kingdom-auth keys generate --out <dir>"
+	generated := writeKeyPair(t, dir, "ES256", "private_key.pem", "public_key.pem")
+
+	// the public key sorts before its private key
+	other := writeKeyPair(t, dir, "EdDSA", "b_private.pem", "a_public.pem")
+
+	// a key that can only verify
+	verifyOnly := writeKeyPair(t, t.TempDir(), "ES384", "private.pem", "")
+	publicPEM, err := EncodePublicKey(verifyOnly.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "old_public.pem"), publicPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Token.KeysDir = dir
+	cfg.Token.PrivateKeyPath = filepath.Join(dir, "missing.pem")
+
+	r, err := LoadRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := r.Status()
+	if len(status) != 3 {
+		t.Fatalf("got %d keys, want 3", len(status))
+	}
+
+	for _, tt := range []struct {
+		key     *Key
+		canSign bool
+	}{{generated, true}, {other, true}, {verifyOnly, false}} {
+		k, ok := r.Get(tt.key.ID)
+		if !ok {
+			t.Errorf("key %s (%s) not loaded", tt.key.ID, tt.key.Source)
+			continue
+		}
+
+		if k.CanSign() != tt.canSign {
+			t.Errorf("key %s (%s): CanSign = %v, want %v", tt.key.ID, tt.key.Source, k.CanSign(), tt.canSign)
+		}
+	}
+}
+
+func TestLoadRingAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := filepath.Join(dir, "keys")
+
+	if err := os.Mkdir(keysDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := writeKeyPair(t, dir, "RS256", "private_key.pem", "public_key.pem")
+	ec := writeKeyPair(t, keysDir, "ES256", "ec_private.pem", "ec_public.pem")
+	rsa := writeKeyPair(t, keysDir, "RS512", "rsa_private.pem", "")
+	listed := writeKeyPair(t, keysDir, "RS384", "listed_private.pem", "")
+
+	cfg := &config.Config{}
+	cfg.Token.PrivateKeyPath = filepath.Join(dir, "private_key.pem")
+	cfg.Token.PublicKeyPath = filepath.Join(dir, "public_key.pem")
+	cfg.Token.Algorithm = "RS256"
+	cfg.Token.KeysDir = keysDir
+	cfg.Token.Keys = []config.KeyConfig{{PrivateKeyPath: filepath.Join(keysDir, "listed_private.pem"), Algorithm: "RS384"}}
+
+	r, err := LoadRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []*Key{legacy, ec, rsa, listed} {
+		k, ok := r.Get(want.ID)
+		if !ok {
+			t.Errorf("key %s (%s) not loaded", want.ID, want.Source)
+			continue
+		}
+
+		if k.Method().Alg() != want.Method().Alg() {
+			t.Errorf("key %s (%s) signs with %s, want %s", k.ID, k.Source, k.Method().Alg(), want.Method().Alg())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const clientsUsage = `usage: kingdom-auth clients <command> [flags]
+
+commands:
+  secret     generate a client secret and its secret_hash (OpenID Connect and service clients)
+
+run "kingdom-auth clients <command> -h" for the flags of a command
+`
+
+// runClients runs the "clients" subcommand and returns the exit code.
+func runClients(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, clientsUsage)
+		return 2
+	}
+
+	var err error
+
+	switch args[0] {
+	case "secret":
+		err = clientsSecret(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(clientsUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], clientsUsage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func clientsSecret(args []string) error {
+	fs := flag.NewFlagSet("clients secret", flag.ContinueOnError)
+	secret := fs.String("secret", "", "hash this secret instead of generating a new one")
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			return err
+		}
+
+		*secret = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*secret), *cost)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("secret:     ", *secret)
+	fmt.Println("secret_hash:", string(hash))
+
+	return nil
+}
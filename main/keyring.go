@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/5000K/kingdom-auth/keys"
+)
+
+// loadKeyRing loads the configured keys and applies the changes made to the keyring at runtime (via the system service).
+func loadKeyRing(cfg *config.Config, driver *db.Driver) (*keys.Ring, error) {
+	ring, err := keys.LoadRing(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := driver.GetKeyStates()
+	if err != nil {
+		return nil, err
+	}
+
+	log := slog.With("source", "keyring")
+
+	// promote first, so retiring the configured active key works once another key was promoted
+	for _, st := range states {
+		if st.Active {
+			err := ring.Promote(st.Kid)
+			if err != nil {
+				log.Warn("can't activate key that was promoted before - keeping the configured active key", "kid", st.Kid, "error", err)
+			}
+		}
+	}
+
+	for _, st := range states {
+		if st.Retired {
+			err := ring.Retire(st.Kid)
+			if err != nil && !errors.Is(err, keys.ErrUnknownKey) {
+				log.Warn("can't retire key that was retired before", "kid", st.Kid, "error", err)
+			}
+		}
+	}
+
+	log.Info("keyring loaded", "active", ring.Active().ID, "keys", len(ring.Status()))
+
+	return ring, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/5000K/kingdom-auth/keys"
+)
+
+const keysUsage = `usage: kingdom-auth keys <command> [flags]
+
+commands:
+  generate   generate a new key pair
+  show       print kid, fingerprint and JWK of a key
+  verify     check that a private and a public key file belong together
+
+run "kingdom-auth keys <command> -h" for the flags of a command
+`
+
+// runKeys runs the "keys" subcommand and returns the exit code.
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	var err error
+
+	switch args[0] {
+	case "generate":
+		err = keysGenerate(args[1:])
+	case "show":
+		err = keysShow(args[1:])
+	case "verify":
+		err = keysVerify(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(keysUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], keysUsage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func keysGenerate(args []string) error {
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	alg := fs.String("alg", "rs512", "signing algorithm: rs256, rs384, rs512, es256, es384, es512 or eddsa")
+	out := fs.String("out", ".", "directory to write the key files to")
+	name := fs.String("name", "", "write <name>_private.pem and <name>_public.pem instead of private_key.pem and public_key.pem, e.g. to add a key to keys_dir")
+	bits := fs.Int("bits", 4096, "key size for RSA keys")
+	force := fs.Bool("force", false, "overwrite existing key files")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	privatePath := filepath.Join(*out, "private_key.pem")
+	publicPath := filepath.Join(*out, "public_key.pem")
+
+	if *name != "" {
+		privatePath = filepath.Join(*out, *name+"_private.pem")
+		publicPath = filepath.Join(*out, *name+"_public.pem")
+	}
+
+	if !*force {
+		for _, p := range []string{privatePath, publicPath} {
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("%s already exists (use -name to write another key next to it, or -force to overwrite it)", p)
+			}
+		}
+	}
+
+	private, err := keys.Generate(*alg, *bits)
+	if err != nil {
+		return err
+	}
+
+	privatePEM, err := keys.EncodePrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	publicPEM, err := keys.EncodePublicKey(private.Public())
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(*out, 0o755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(privatePath, privatePEM, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(publicPath, publicPEM, 0o644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("wrote", privatePath)
+	fmt.Println("wrote", publicPath)
+
+	if a := strings.ToUpper(*alg); a == "RS256" || a == "RS384" {
+		fmt.Printf("RSA keys sign with RS512 by default - set token.algorithm to %s in your config\n", a)
+	}
+
+	return printKey(private.Public(), strings.ToUpper(*alg))
+}
+
+func keysShow(args []string) error {
+	fs := flag.NewFlagSet("keys show", flag.ContinueOnError)
+	privatePath := fs.String("private", "", "private key file (either this or -public)")
+	publicPath := fs.String("public", "public_key.pem", "public key file")
+	alg := fs.String("alg", "", "signing algorithm, only relevant for RSA keys (default RS512)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var public crypto.PublicKey
+
+	if *privatePath != "" {
+		private, err := keys.LoadPrivateKey(*privatePath)
+		if err != nil {
+			return err
+		}
+
+		public = private.Public()
+	} else {
+		public, err = keys.LoadPublicKey(*publicPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return printKey(public, strings.ToUpper(*alg))
+}
+
+func keysVerify(args []string) error {
+	fs := flag.NewFlagSet("keys verify", flag.ContinueOnError)
+	privatePath := fs.String("private", "private_key.pem", "private key file")
+	publicPath := fs.String("public", "public_key.pem", "public key file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	private, err := keys.LoadPrivateKey(*privatePath)
+	if err != nil {
+		return err
+	}
+
+	public, err := keys.LoadPublicKey(*publicPath)
+	if err != nil {
+		return err
+	}
+
+	if !keys.Matches(private, public) {
+		return fmt.Errorf("%s and %s are not a key pair", *privatePath, *publicPath)
+	}
+
+	fmt.Printf("%s and %s are a matching key pair\n", *privatePath, *publicPath)
+
+	return printKey(public, "")
+}
+
+// printKey prints kid, algorithm, fingerprint and JWK of a public key.
+func printKey(public crypto.PublicKey, alg string) error {
+	if alg == "EDDSA" || alg == "ED25519" {
+		alg = "EdDSA"
+	}
+
+	method, err := keys.MethodFor(public, alg)
+	if err != nil {
+		return err
+	}
+
+	jwk, err := keys.PublicJWK(public, method.Alg())
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := keys.Fingerprint(public)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := json.MarshalIndent(jwk, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("kid:        ", jwk.Kid)
+	fmt.Println("alg:        ", jwk.Alg)
+	fmt.Println("fingerprint:", "SHA256:"+fingerprint)
+	fmt.Println("jwk:")
+	fmt.Println(string(formatted))
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/keys"
+)
+
+func TestKeysGenerateTwice(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"-alg", "es256", "-out", dir},
+		{"-alg", "eddsa", "-out", dir, "-name", "2026-10"},
+	} {
+		if err := keysGenerate(args); err != nil {
+			t.Fatalf("keys generate %v: %v", args, err)
+		}
+	}
+
+	// existing files are never overwritten without -force
+	for _, args := range [][]string{
+		{"-alg", "es256", "-out", dir},
+		{"-alg", "es256", "-out", dir, "-name", "2026-10"},
+	} {
+		if err := keysGenerate(args); err == nil {
+			t.Errorf("keys generate %v: overwrote existing key files", args)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Token.KeysDir = dir
+	cfg.Token.PrivateKeyPath = filepath.Join(dir, "missing.pem")
+
+	r, err := keys.LoadRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := r.Status()
+	if len(status) != 2 {
+		t.Fatalf("got %d keys, want 2", len(status))
+	}
+
+	for _, k := range status {
+		if !k.CanSign {
+			t.Errorf("key %s can't sign", k.ID)
+		}
+	}
+}
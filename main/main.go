@@ -1,12 +1,23 @@
 package main
 
 import (
+	"os"
+
 	"github.com/5000K/kingdom-auth/config"
 	"github.com/5000K/kingdom-auth/db"
 	"github.com/5000K/kingdom-auth/service"
+	"github.com/5000K/kingdom-auth/sysservice"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "clients" {
+		os.Exit(runClients(os.Args[2:]))
+	}
+
 	cfg, err := config.Get()
 
 	if err != nil {
@@ -23,12 +34,28 @@ func main() {
 
 	printBanner()
 
-	srv, err := service.NewService(cfg, driver)
+	ring, err := loadKeyRing(cfg, driver)
+
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	srv, err := service.NewService(cfg, driver, ring)
 
 	if err != nil {
 		println(err.Error())
 		return
 	}
 
+	sysSrv, err := sysservice.NewService(cfg, driver, ring)
+
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	go sysSrv.Run()
+
 	srv.Run()
 }
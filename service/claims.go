@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/5000K/kingdom-auth/config"
+)
+
+// claimPath is a parsed claim selector. Elements are object keys (string) or array indexes (int).
+type claimPath []any
+
+// parseClaimPath parses a JSONPath-like selector: "$.profile.emails[0].value", "profile.name" or "$['urn:groups']".
+// The leading "$" is optional. An empty selector results in an empty path, which never matches.
+func parseClaimPath(selector string) (claimPath, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	path := make(claimPath, 0)
+
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' || rest[0] == '[' {
+				return nil, fmt.Errorf("invalid claim selector %q: empty key", selector)
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid claim selector %q: missing ]", selector)
+			}
+
+			inner := rest[1:end]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, inner[1:len(inner)-1])
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid claim selector %q: bad index %q", selector, inner)
+				}
+
+				path = append(path, idx)
+			}
+
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+
+			path = append(path, rest[:end])
+			rest = rest[end:]
+		}
+	}
+
+	return path, nil
+}
+
+// lookup returns the value the path points to.
+func (p claimPath) lookup(claims map[string]any) (any, bool) {
+	if len(p) == 0 {
+		return nil, false
+	}
+
+	var current any = claims
+
+	for _, elem := range p {
+		switch elem := elem.(type) {
+		case string:
+			obj, ok := current.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+
+			current, ok = obj[elem]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := current.([]any)
+			if !ok || elem >= len(arr) {
+				return nil, false
+			}
+
+			current = arr[elem]
+		}
+	}
+
+	return current, current != nil
+}
+
+// claimMapping builds an identity from the claims of a provider.
+type claimMapping struct {
+	subject       claimPath
+	email         claimPath
+	emailVerified claimPath
+	name          claimPath
+	avatar        claimPath
+	groups        claimPath
+}
+
+// defaultClaims are the standard OIDC claims.
+var defaultClaims = config.ClaimsConfig{
+	Subject:       "sub",
+	Email:         "email",
+	EmailVerified: "email_verified",
+	Name:          "name",
+	Avatar:        "picture",
+	Groups:        "groups",
+}
+
+// newClaimMapping parses the configured selectors. Selectors that aren't configured fall back to the defaults.
+func newClaimMapping(cfg config.ClaimsConfig, defaults config.ClaimsConfig) (*claimMapping, error) {
+	m := &claimMapping{}
+
+	selectors := []struct {
+		target     *claimPath
+		configured string
+		fallback   string
+	}{
+		{&m.subject, cfg.Subject, defaults.Subject},
+		{&m.email, cfg.Email, defaults.Email},
+		{&m.emailVerified, cfg.EmailVerified, defaults.EmailVerified},
+		{&m.name, cfg.Name, defaults.Name},
+		{&m.avatar, cfg.Avatar, defaults.Avatar},
+		{&m.groups, cfg.Groups, defaults.Groups},
+	}
+
+	for _, sel := range selectors {
+		selector := sel.configured
+		if selector == "" {
+			selector = sel.fallback
+		}
+
+		path, err := parseClaimPath(selector)
+		if err != nil {
+			return nil, err
+		}
+
+		*sel.target = path
+	}
+
+	return m, nil
+}
+
+// identity maps the claims to an identity. The claims are kept as they are, for later use.
+func (m *claimMapping) identity(claims map[string]any) (*identity, error) {
+	id := &identity{
+		Claims: claims,
+	}
+
+	subject, _ := m.subject.lookup(claims)
+	id.Subject = claimString(subject)
+
+	if id.Subject == "" {
+		return nil, fmt.Errorf("claims have no subject")
+	}
+
+	email, _ := m.email.lookup(claims)
+	id.Email = claimString(email)
+
+	name, _ := m.name.lookup(claims)
+	id.Name = claimString(name)
+
+	avatar, _ := m.avatar.lookup(claims)
+	id.Avatar = claimString(avatar)
+
+	switch v, _ := m.emailVerified.lookup(claims); v := v.(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		// some providers send it as string
+		id.EmailVerified = v == "true"
+	}
+
+	switch v, _ := m.groups.lookup(claims); v := v.(type) {
+	case []any:
+		for _, group := range v {
+			if s := claimString(group); s != "" {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		// a single group, or a space separated list like scopes
+		id.Groups = strings.Fields(v)
+	}
+
+	return id, nil
+}
+
+// claimString converts a string or numeric claim to a string.
+func claimString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return ""
+}
+
+// decodeClaims decodes a JSON object, keeping numbers exact.
+func decodeClaims(raw []byte) (map[string]any, error) {
+	var claims map[string]any
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	err := dec.Decode(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClaimPath(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     claimPath
+		wantErr  bool
+	}{
+		{"", claimPath{}, false},
+		{"$", claimPath{}, false},
+		{"sub", claimPath{"sub"}, false},
+		{"$.sub", claimPath{"sub"}, false},
+		{" $.sub ", claimPath{"sub"}, false},
+		{"profile.name", claimPath{"profile", "name"}, false},
+		{"$.profile.emails[0].value", claimPath{"profile", "emails", 0, "value"}, false},
+		{"$['urn:groups']", claimPath{"urn:groups"}, false},
+		{`$["a.b"].c`, claimPath{"a.b", "c"}, false},
+		{"$.a[12][3]", claimPath{"a", 12, 3}, false},
+		{"[0]", claimPath{0}, false},
+
+		{"$.", nil, true},
+		{"$..a", nil, true},
+		{"$.[0]", nil, true},
+		{"$.a[", nil, true},
+		{"$.a[x]", nil, true},
+		{"$.a[-1]", nil, true},
+		{"$.a['b]", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseClaimPath(tt.selector)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseClaimPath(%q) error = %v, wantErr %v", tt.selector, err, tt.wantErr)
+			continue
+		}
+
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseClaimPath(%q) = %#v, want %#v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestClaimPathLookup(t *testing.T) {
+	claims := map[string]any{
+		"sub":        "123",
+		"urn:groups": []any{"admins", "dev"},
+		"profile": map[string]any{
+			"emails": []any{
+				map[string]any{"value": "a@example.com"},
+			},
+		},
+	}
+
+	tests := []struct {
+		selector string
+		want     any
+		found    bool
+	}{
+		{"sub", "123", true},
+		{"$['urn:groups'][1]", "dev", true},
+		{"$.profile.emails[0].value", "a@example.com", true},
+		{"$.profile.emails[1].value", nil, false},
+		{"$.sub.x", nil, false},
+		{"$.sub[0]", nil, false},
+		{"missing", nil, false},
+		{"$", nil, false},
+	}
+
+	for _, tt := range tests {
+		p, err := parseClaimPath(tt.selector)
+		if err != nil {
+			t.Fatalf("parseClaimPath(%q): %v", tt.selector, err)
+		}
+
+		got, found := p.lookup(claims)
+		if found != tt.found || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup(%q) = %v, %v, want %v, %v", tt.selector, got, found, tt.want, tt.found)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// findClient returns the registered client with the given id.
+func (s *Service) findClient(id string) (*config.ClientConfig, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	for i := range s.config.OIDC.Clients {
+		if s.config.OIDC.Clients[i].ID == id {
+			return &s.config.OIDC.Clients[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// redirectURIAllowed checks a redirect_uri against the ones registered for the client (exact match, RFC 9700 section 4.1.3).
+func redirectURIAllowed(client *config.ClientConfig, redirectURI string) bool {
+	return redirectURI != "" && slices.Contains(client.RedirectURIs, redirectURI)
+}
+
+// clientCredentials reads the id and secret of the client of a token request, from HTTP Basic authentication (client_secret_basic)
+// or the client_id and client_secret form fields (client_secret_post).
+func clientCredentials(c *gin.Context) (id string, secret string) {
+	id, secret, basic := c.Request.BasicAuth()
+
+	if !basic {
+		return c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	// the credentials are form encoded before they're put into the header (RFC 6749, section 2.3.1)
+	if unescaped, err := url.QueryUnescape(id); err == nil {
+		id = unescaped
+	}
+
+	if unescaped, err := url.QueryUnescape(secret); err == nil {
+		secret = unescaped
+	}
+
+	return id, secret
+}
+
+// authenticateClient identifies the OpenID Connect client of a token request, see clientCredentials. Public clients only send
+// their client_id. Returns false if the client is unknown or the secret doesn't match.
+func (s *Service) authenticateClient(c *gin.Context) (*config.ClientConfig, bool) {
+	id, secret := clientCredentials(c)
+
+	client, ok := s.findClient(id)
+	if !ok {
+		// like authenticateServiceClient, the response time doesn't tell which client ids exist
+		_ = bcrypt.CompareHashAndPassword(dummySecretHash, []byte(secret))
+		return nil, false
+	}
+
+	if client.SecretHash == "" {
+		// public client
+		return client, secret == ""
+	}
+
+	return client, secret != "" && bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) == nil
+}
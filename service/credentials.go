@@ -0,0 +1,124 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// findServiceClient returns the service client with the given id.
+func (s *Service) findServiceClient(id string) (*config.ServiceClientConfig, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	for i := range s.config.ServiceClients.Clients {
+		if s.config.ServiceClients.Clients[i].ID == id {
+			return &s.config.ServiceClients.Clients[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// dummySecretHash is compared against for unknown clients, so the response time doesn't tell which client ids exist.
+var dummySecretHash = []byte("$2a$10$4o2HQRjwbDCTflVBWzuf.O0ChjCTjPyWwPEgYiPwvJA/V2j3xwz8y")
+
+// authenticateServiceClient identifies the service client of a token request, see clientCredentials.
+func (s *Service) authenticateServiceClient(c *gin.Context) (*config.ServiceClientConfig, bool) {
+	id, secret := clientCredentials(c)
+
+	hash := dummySecretHash
+	client, ok := s.findServiceClient(id)
+	if ok {
+		hash = []byte(client.SecretHash)
+	}
+
+	matches := bcrypt.CompareHashAndPassword(hash, []byte(secret)) == nil
+	if !ok || secret == "" || !matches {
+		return nil, false
+	}
+
+	return client, true
+}
+
+// requestedSubset returns the requested values, or all allowed values if none were requested.
+// Returns false if a requested value isn't allowed.
+func requestedSubset(requested []string, allowed []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return allowed, true
+	}
+
+	subset := make([]string, 0, len(requested))
+	for _, v := range requested {
+		if !slices.Contains(allowed, v) {
+			return nil, false
+		}
+
+		if !slices.Contains(subset, v) {
+			subset = append(subset, v)
+		}
+	}
+
+	return subset, true
+}
+
+// clientCredentialsGrant issues a token to a service client (RFC 6749, section 4.4). The token is signed like auth tokens,
+// its subject is the client id.
+func (s *Service) clientCredentialsGrant(c *gin.Context) {
+	client, ok := s.authenticateServiceClient(c)
+	if !ok {
+		rejectClient(c)
+		return
+	}
+
+	audiences, ok := requestedSubset(c.PostFormArray("audience"), client.Audiences)
+	if !ok || len(audiences) == 0 {
+		oauthError(c, http.StatusBadRequest, "invalid_target", "the client may not request tokens for this audience")
+		return
+	}
+
+	scopes, ok := requestedSubset(strings.Fields(c.PostForm("scope")), client.Scopes)
+	if !ok {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", "the client may not request this scope")
+		return
+	}
+
+	exp := time.Now().Add(time.Second * time.Duration(s.config.ServiceClients.TokenTTL)).Unix()
+	scope := strings.Join(scopes, " ")
+
+	tk, err := s.sign(jwt.MapClaims{
+		"sub":                        client.ID,
+		"aud":                        audiences,
+		"iss":                        s.config.Token.Issuer,
+		"exp":                        exp,
+		"iat":                        time.Now().Unix(),
+		core.SubjectTypeClaim:        core.SubjectTypeClient,
+		core.ClientIDClaim:           client.ID,
+		core.ScopeClaim:              scope,
+		core.TokenUseClaim:           core.TokenUseService,
+		core.KingdomAuthVersionClaim: core.KingdomAuthVersion,
+	})
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	s.log.Info("issued service token", "client", client.ID, "audience", audiences, "scope", scope)
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": tk,
+		"token_type":   "Bearer",
+		"expires_in":   s.config.ServiceClients.TokenTTL,
+		"scope":        scope,
+	})
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// user codes only use consonants: easy to type, no ambiguous characters and no accidental words (RFC 8628, section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+var devicePage = template.Must(template.New("device").Parse(`<html><body>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form method="get">
+<p><label>Code shown on your device: <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+<p>Log in with:
+{{range .Providers}}<button type="submit" formaction="{{$.Base}}/auth/begin/{{.}}">{{.}}</button> {{end}}
+</p>
+</form>
+</body></html>`))
+
+var deviceApprovedPage = []byte("<html><body><h1>Your device is connected.</h1>You may now close this window/tab and return to your device.</body></html>")
+
+// newUserCode returns a random user code like "BDFG-HJKL".
+func newUserCode() (string, error) {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := make([]byte, 0, 9)
+	for i, v := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+
+		// 256 is no multiple of 20, the slight bias doesn't matter here
+		code = append(code, userCodeAlphabet[int(v)%len(userCodeAlphabet)])
+	}
+
+	return string(code), nil
+}
+
+// normalizeUserCode makes user input comparable to stored user codes: case and separators don't matter.
+func normalizeUserCode(input string) string {
+	letters := make([]byte, 0, 8)
+
+	for _, r := range strings.ToUpper(input) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			letters = append(letters, byte(r))
+		}
+	}
+
+	if len(letters) != 8 {
+		return ""
+	}
+
+	return string(letters[:4]) + "-" + string(letters[4:])
+}
+
+// hashCode hashes device and authorization codes for storage. They are random and long, so a plain hash is enough.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// oauthError writes an OAuth error response (RFC 6749, section 5.2).
+func oauthError(c *gin.Context, status int, code string, description string) {
+	res := gin.H{
+		"error": code,
+	}
+
+	if description != "" {
+		res["error_description"] = description
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, res)
+}
+
+func deviceError(c *gin.Context, code string) {
+	oauthError(c, http.StatusBadRequest, code, "")
+}
+
+// deviceCode handles POST /device/code: it starts a device authorization.
+func (s *Service) deviceCode(c *gin.Context) {
+	deviceCode, err := randomString(32)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create device code error", "error", err)
+		return
+	}
+
+	code := &db.DeviceCode{
+		DeviceCodeHash: hashCode(deviceCode),
+		ExpiresAt:      time.Now().Add(time.Second * time.Duration(s.config.Device.CodeTTL)),
+	}
+
+	// user codes are short, so a collision with a pending code is possible
+	for attempt := 0; attempt < 3; attempt++ {
+		code.UserCode, err = newUserCode()
+		if err != nil {
+			break
+		}
+
+		err = s.db.CreateDeviceCode(code)
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create device code error", "error", err)
+		return
+	}
+
+	verificationURI := s.config.MainService.PublicUrl + "/device"
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 code.UserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + url.QueryEscape(code.UserCode),
+		"expires_in":                s.config.Device.CodeTTL,
+		"interval":                  s.config.Device.Interval,
+	})
+}
+
+// devicePage handles GET /device: the page where the user enters the user code and picks a provider to log in with.
+func (s *Service) devicePage(c *gin.Context) {
+	s.writeDevicePage(c, http.StatusOK, c.Query("user_code"), "")
+}
+
+func (s *Service) writeDevicePage(c *gin.Context, status int, userCode string, message string) {
+	providers := make([]string, 0, len(s.config.OAuthProviders))
+	for _, p := range s.config.OAuthProviders {
+		providers = append(providers, p.Name)
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.WriteHeader(status)
+
+	err := devicePage.Execute(c.Writer, gin.H{
+		"Base":      s.config.MainService.PublicUrl,
+		"UserCode":  userCode,
+		"Message":   message,
+		"Providers": providers,
+	})
+	if err != nil {
+		s.log.Info("render device page error", "error", err)
+	}
+}
+
+// checkUserCode validates a user code entered on the device page before the login starts.
+// On failure, the device page is shown again and false is returned.
+func (s *Service) checkUserCode(c *gin.Context, input string) (string, bool) {
+	if !s.config.Device.Enabled {
+		writeErrorPage(c, http.StatusNotFound, "Device login is not enabled.")
+		return "", false
+	}
+
+	userCode := normalizeUserCode(input)
+
+	if userCode != "" {
+		code, err := s.db.GetDeviceCodeByUserCode(userCode)
+		if err == nil && code.IsPending() {
+			return userCode, true
+		}
+
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("get device code error", "error", err)
+			return "", false
+		}
+	}
+
+	s.writeDevicePage(c, http.StatusBadRequest, input, "This code is invalid or expired. Please check the code shown on your device.")
+	return "", false
+}
+
+// finishDeviceLogin completes a login that approves a device: the device gets the tokens, the browser doesn't.
+func (s *Service) finishDeviceLogin(c *gin.Context, st *authState, user *db.User) {
+	err := s.db.ApproveDeviceCode(st.DeviceUserCode, user.ID)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeErrorPage(c, http.StatusBadRequest, "The code of your device expired. Please start again on your device.")
+		return
+	}
+
+	if err != nil {
+		s.log.Info("approve device code error", "error", err)
+		writeErrorPage(c, http.StatusInternalServerError, "Something went wrong on our side.")
+		return
+	}
+
+	s.log.Info("approved device", "user", user.ID)
+
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	_, _ = c.Writer.Write(deviceApprovedPage)
+}
+
+// deviceToken handles POST /device/token: the device polls until the user approved it, then gets its tokens.
+func (s *Service) deviceToken(c *gin.Context) {
+	if c.PostForm("grant_type") != deviceCodeGrantType {
+		deviceError(c, "unsupported_grant_type")
+		return
+	}
+
+	code, err := s.db.GetDeviceCode(hashCode(c.PostForm("device_code")))
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		deviceError(c, "invalid_grant")
+		return
+	}
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("get device code error", "error", err)
+		return
+	}
+
+	if code.Denied {
+		deviceError(c, "access_denied")
+		return
+	}
+
+	// approved codes expire too - the device has to poll for its tokens in time
+	if !code.ExpiresAt.After(time.Now()) {
+		deviceError(c, "expired_token")
+		return
+	}
+
+	if code.UserID == nil {
+		previous, err := s.db.PollDeviceCode(code)
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("poll device code error", "error", err)
+			return
+		}
+
+		if previous != nil && time.Since(*previous) < time.Second*time.Duration(s.config.Device.Interval) {
+			deviceError(c, "slow_down")
+			return
+		}
+
+		deviceError(c, "authorization_pending")
+		return
+	}
+
+	// approved: the tokens are handed out exactly once
+	err = s.db.ConsumeDeviceCode(code)
+	if errors.Is(err, core.ErrDeviceCodeExpired) {
+		deviceError(c, "expired_token")
+		return
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		deviceError(c, "invalid_grant")
+		return
+	}
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("consume device code error", "error", err)
+		return
+	}
+
+	user, err := s.db.GetUser(uint32(*code.UserID))
+	if err != nil {
+		deviceError(c, "invalid_grant")
+		return
+	}
+
+	refreshToken, err := s.createRefreshTokenFor(user)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	authToken, exp, err := s.createAuthTokenFor(user)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  authToken,
+		"token_type":    "Bearer",
+		"expires_in":    exp - time.Now().Unix(),
+		"refresh_token": refreshToken,
+	})
+}
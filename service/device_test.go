@@ -0,0 +1,139 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"BCDF-GHJK", "BCDF-GHJK"},
+		{"bcdf-ghjk", "BCDF-GHJK"},
+		{"BCDFGHJK", "BCDF-GHJK"},
+		{" bcdf ghjk ", "BCDF-GHJK"},
+		{"B-C-D-F-G-H-J-K", "BCDF-GHJK"},
+
+		// characters outside of the alphabet are ignored
+		{"BCDF-GHJK1", "BCDF-GHJK"},
+
+		// too short or too long
+		{"", ""},
+		{"BCDF-GHJ", ""},
+		{"BCDF-GHJA", ""},
+		{"BCDF-GHJK-L", ""},
+		{"1234-5678", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeUserCode(tt.input); got != tt.want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNewUserCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := newUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(code) != 9 || code[4] != '-' {
+			t.Fatalf("newUserCode() = %q, want XXXX-XXXX", code)
+		}
+
+		if strings.Trim(strings.ReplaceAll(code, "-", ""), userCodeAlphabet) != "" {
+			t.Fatalf("newUserCode() = %q, has letters outside of the alphabet", code)
+		}
+
+		if normalizeUserCode(code) != code {
+			t.Fatalf("normalizeUserCode(%q) = %q, want the code itself", code, normalizeUserCode(code))
+		}
+	}
+}
+
+func TestDeviceTokenExpiry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+
+	user, err := s.db.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/device/token", s.deviceToken)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		status    int
+		error     string
+	}{
+		{name: "approved", expiresAt: time.Now().Add(time.Minute), status: http.StatusOK},
+		{name: "approved but expired", expiresAt: time.Now().Add(-time.Second), status: http.StatusBadRequest, error: "expired_token"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceCode := strings.Repeat("d", i+1)
+
+			err := s.db.CreateDeviceCode(&db.DeviceCode{
+				DeviceCodeHash: hashCode(deviceCode),
+				UserCode:       strings.Repeat("B", i+1),
+				ExpiresAt:      tt.expiresAt,
+				UserID:         &user.ID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			form := url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}}
+			req := httptest.NewRequest(http.MethodPost, "/device/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.status || (tt.error != "" && body["error"] != tt.error) {
+				t.Errorf("POST /device/token = %d %v, want %d %q", w.Code, body, tt.status, tt.error)
+			}
+		})
+	}
+}
+
+func TestConsumeExpiredDeviceCode(t *testing.T) {
+	s := newTestService(t)
+
+	user, err := s.db.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := &db.DeviceCode{DeviceCodeHash: hashCode("device"), UserCode: "BCDF-GHJK", ExpiresAt: time.Now().Add(-time.Second), UserID: &user.ID}
+	if err := s.db.CreateDeviceCode(code); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.db.ConsumeDeviceCode(code); !errors.Is(err, core.ErrDeviceCodeExpired) {
+		t.Errorf("ConsumeDeviceCode() error = %v, want %v", err, core.ErrDeviceCodeExpired)
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/gin-gonic/gin"
+)
+
+// token exchange, see https://www.rfc-editor.org/rfc/rfc8693
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// private userdata key that lists the restricted audiences a user may get tokens for
+const userdataAudiencesKey = "audiences"
+
+// findExchangeAudience returns the exchange configuration of an audience.
+func (s *Service) findExchangeAudience(audience string) (*config.ExchangeAudienceConfig, bool) {
+	for i := range s.config.TokenExchange.Audiences {
+		if s.config.TokenExchange.Audiences[i].Audience == audience {
+			return &s.config.TokenExchange.Audiences[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// userdataList reads a userdata value that holds a list of strings.
+func userdataList(v any) []string {
+	raw, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+
+	list := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// exchangeToken handles POST /token/exchange: it exchanges a valid auth token for a token of another audience
+// with a narrower scope, so it can be passed on to internal services.
+func (s *Service) exchangeToken(c *gin.Context) {
+	if c.PostForm("grant_type") != tokenExchangeGrantType {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	if t := c.PostForm("subject_token_type"); t != tokenTypeAccessToken && t != tokenTypeJWT {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "subject_token_type has to be an access token or jwt")
+		return
+	}
+
+	if t := c.PostForm("requested_token_type"); t != "" && t != tokenTypeAccessToken && t != tokenTypeJWT {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "only access tokens can be requested")
+		return
+	}
+
+	subject, err := s.readAuthToken(c.PostForm("subject_token"))
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "subject_token is invalid or expired")
+		return
+	}
+
+	// only auth tokens (and tokens exchanged from them) belong to a user kingdom-auth issued them for - refresh tokens and
+	// tokens of clients are rejected
+	iss, _ := subject.GetIssuer()
+	_, isClientToken := subject[core.ClientIDClaim]
+	_, hasJTI := subject["jti"]
+
+	if subject[core.TokenUseClaim] != core.TokenUseAuth || isClientToken || hasJTI ||
+		iss != s.config.Token.Issuer || subject[core.KingdomAuthVersionClaim] != core.KingdomAuthVersion {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "subject_token is no auth token")
+		return
+	}
+
+	audiences := c.PostFormArray("audience")
+	if len(audiences) != 1 {
+		oauthError(c, http.StatusBadRequest, "invalid_target", "exactly one audience is required")
+		return
+	}
+
+	target, ok := s.findExchangeAudience(audiences[0])
+	if !ok {
+		oauthError(c, http.StatusBadRequest, "invalid_target", "tokens can't be exchanged for this audience")
+		return
+	}
+
+	sub, _ := subject.GetSubject()
+
+	var uid uint32
+	_, err = fmt.Sscanf(sub, "%d", &uid)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "subject_token is no auth token")
+		return
+	}
+
+	user, err := s.db.GetUser(uid)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "the user of the subject_token doesn't exist")
+		return
+	}
+
+	_, permissions, err := s.db.RolesAndPermissions(user.ID)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("read permissions error", "error", err)
+		return
+	}
+
+	// users only get the scopes of the audience they have the permission for
+	allowed := slices.DeleteFunc(slices.Clone(target.Scopes), func(scope string) bool {
+		return !slices.Contains(permissions, scope)
+	})
+
+	// an exchanged token can only be narrowed down further
+	if raw, ok := subject[core.ScopeClaim].(string); ok {
+		granted := strings.Fields(raw)
+		allowed = slices.DeleteFunc(allowed, func(scope string) bool {
+			return !slices.Contains(granted, scope)
+		})
+	}
+
+	// a token without any scope would be useless for the audience
+	scopes, ok := requestedSubset(strings.Fields(c.PostForm("scope")), allowed)
+	if !ok || len(scopes) == 0 {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", "the scope can't be requested for this audience")
+		return
+	}
+
+	if target.Restricted {
+		private, err := user.GetPrivateUserdata()
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("read userdata error", "error", err)
+			return
+		}
+
+		if !slices.Contains(userdataList(private[userdataAudiencesKey]), target.Audience) {
+			s.log.Info("rejected token exchange", "user", user.ID, "audience", target.Audience)
+			oauthError(c, http.StatusBadRequest, "invalid_target", "the user may not get tokens for this audience")
+			return
+		}
+	}
+
+	exp := time.Now().Add(time.Second * time.Duration(s.config.TokenExchange.TokenTTL))
+
+	// the new token doesn't outlive the one it was exchanged for
+	if subjectExp, err := subject.GetExpirationTime(); err == nil && subjectExp != nil && subjectExp.Before(exp) {
+		exp = subjectExp.Time
+	}
+
+	claims, err := s.authTokenClaims(user, exp.Unix())
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	scope := strings.Join(scopes, " ")
+	claims["aud"] = target.Audience
+	claims[core.ScopeClaim] = scope
+
+	tk, err := s.sign(claims)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":      tk,
+		"issued_token_type": tokenTypeAccessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int64(time.Until(exp).Seconds()),
+		"scope":             scope,
+	})
+}
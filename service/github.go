@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/5000K/kingdom-auth/config"
+	"golang.org/x/oauth2"
+)
+
+// defaults for github.com, override the endpoints for GitHub Enterprise Server
+const (
+	githubAuthURL     = "https://github.com/login/oauth/authorize"
+	githubTokenURL    = "https://github.com/login/oauth/access_token"
+	githubUserInfoURL = "https://api.github.com/user"
+)
+
+// githubClaims are the fields of the GitHub user.
+var githubClaims = config.ClaimsConfig{
+	Subject: "id",
+	Email:   "email",
+	Name:    "name",
+	Avatar:  "avatar_url",
+}
+
+// createGitHubProvider creates a provider for GitHub, which speaks OAuth2 but not OIDC.
+func createGitHubProvider(cfg *config.OAuthConfig, redirectUrl string) (*Provider, error) {
+	endpoints := cfg.Endpoints
+
+	if endpoints.AuthURL == "" {
+		endpoints.AuthURL = githubAuthURL
+	}
+
+	if endpoints.TokenURL == "" {
+		endpoints.TokenURL = githubTokenURL
+	}
+
+	if endpoints.UserInfoURL == "" {
+		endpoints.UserInfoURL = githubUserInfoURL
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+
+	prov, err := newProvider(cfg, providerTypeGitHub, nil, githubClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	prov.config = oauth2.Config{
+		Scopes:       scopes,
+		ClientID:     cfg.ClientId,
+		ClientSecret: cfg.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  endpoints.AuthURL,
+			TokenURL: endpoints.TokenURL,
+		},
+		RedirectURL: redirectUrl,
+	}
+	prov.userInfoURL = endpoints.UserInfoURL
+
+	return prov, nil
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// githubIdentity maps the GitHub user (by default: the numeric id to the subject, since the login name can be changed)
+// and looks up the primary verified email.
+func (p *Provider) githubIdentity(ctx context.Context, token *oauth2.Token) (*identity, error) {
+	var claims map[string]any
+
+	err := p.getJSON(ctx, token, p.userInfoURL, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := p.claims.identity(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if id.EmailVerified {
+		return id, nil
+	}
+
+	// needs the user:email scope. Without it, the public profile email is used (unverified).
+	var emails []githubEmail
+
+	err = p.getJSON(ctx, token, strings.TrimSuffix(p.userInfoURL, "/")+"/emails", &emails)
+	if err != nil {
+		return id, nil
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			id.Email = email.Email
+			id.EmailVerified = true
+			break
+		}
+	}
+
+	return id, nil
+}
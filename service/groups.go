@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+	"gorm.io/gorm"
+)
+
+// groupMapping grants a role to members of a group.
+type groupMapping struct {
+	group string
+	regex *regexp.Regexp
+	role  string
+}
+
+// newGroupMappings parses the group_mappings of a provider.
+func newGroupMappings(cfgs []config.GroupMappingConfig) ([]groupMapping, error) {
+	mappings := make([]groupMapping, 0, len(cfgs))
+
+	for _, cfg := range cfgs {
+		if cfg.Role == "" {
+			return nil, errors.New("group_mappings: role is required")
+		}
+
+		m := groupMapping{
+			group: cfg.Group,
+			role:  cfg.Role,
+		}
+
+		switch {
+		case cfg.Group != "" && cfg.Regex != "":
+			return nil, fmt.Errorf("group_mappings: role %q has both group and regex", cfg.Role)
+		case cfg.Regex != "":
+			re, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("group_mappings: role %q: %w", cfg.Role, err)
+			}
+
+			m.regex = re
+		case cfg.Group == "":
+			return nil, fmt.Errorf("group_mappings: role %q needs a group or regex", cfg.Role)
+		}
+
+		mappings = append(mappings, m)
+	}
+
+	return mappings, nil
+}
+
+func (m *groupMapping) matches(group string) bool {
+	if m.regex != nil {
+		return m.regex.MatchString(group)
+	}
+
+	return m.group == group
+}
+
+// mappedRoles returns the roles the group mappings grant for the given groups.
+func (p *Provider) mappedRoles(groups []string) []string {
+	roles := make([]string, 0)
+
+	for _, m := range p.groupMappings {
+		if slices.ContainsFunc(groups, m.matches) && !slices.Contains(roles, m.role) {
+			roles = append(roles, m.role)
+		}
+	}
+
+	return roles
+}
+
+// roleSource is the source of role assignments made by a provider's group mappings.
+func (p *Provider) roleSource() string {
+	return "provider:" + p.Name
+}
+
+// syncRoles updates the roles of a user granted by the provider's group mappings, so they follow the groups at the provider.
+// Roles assigned in another way (e.g. manually) are left untouched.
+func (s *Service) syncRoles(provider *Provider, user *db.User, id *identity) error {
+	if len(provider.groupMappings) == 0 {
+		return nil
+	}
+
+	wanted := provider.mappedRoles(id.Groups)
+	source := provider.roleSource()
+
+	assignments, err := s.db.GetRolesFor(user.ID)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]string, len(assignments))
+	for _, a := range assignments {
+		current[a.Role.Name] = a.Source
+	}
+
+	for _, role := range wanted {
+		if _, ok := current[role]; ok {
+			continue
+		}
+
+		err = s.db.AssignRole(user.ID, role, source)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.log.Warn("group mapping refers to unknown role", "provider", provider.Name, "role", role)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.log.Info("group mapping granted role", "provider", provider.Name, "user", user.ID, "role", role)
+	}
+
+	for role, roleSource := range current {
+		if roleSource != source || slices.Contains(wanted, role) {
+			continue
+		}
+
+		err = s.db.RemoveRole(user.ID, role)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		s.log.Info("group mapping removed role", "provider", provider.Name, "user", user.ID, "role", role)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// identity is what kingdom-auth learns about a user from a provider.
+type identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Avatar        string
+	Groups        []string
+
+	// all claims of the provider, as received
+	Claims map[string]any
+}
+
+// identity fetches the identity of the user the token belongs to. nonce is the nonce the ID token has to carry.
+func (p *Provider) identity(ctx context.Context, token *oauth2.Token, nonce string) (*identity, error) {
+	switch p.kind {
+	case providerTypeGitHub:
+		return p.githubIdentity(ctx, token)
+	case providerTypeGeneric:
+		return p.genericIdentity(ctx, token)
+	}
+
+	claims, err := p.idTokenClaims(ctx, token, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	info, infoErr := p.userInfoClaims(ctx, token)
+
+	if claims == nil {
+		// no ID token: userinfo is all there is
+		if infoErr != nil {
+			return nil, infoErr
+		}
+
+		claims = info
+	} else if infoErr == nil && claimString(info["sub"]) == claimString(claims["sub"]) {
+		// the ID token takes precedence, userinfo only fills in what it lacks
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+
+	return p.claims.identity(claims)
+}
+
+// idTokenClaims verifies the ID token of the token response (signature, audience, issuer, expiry and nonce) and returns
+// its claims. Returns nil claims if there is no ID token, or if the provider's keys are unknown.
+func (p *Provider) idTokenClaims(ctx context.Context, token *oauth2.Token, nonce string) (map[string]any, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" || p.verifier == nil {
+		return nil, nil
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("verify id token: nonce mismatch")
+	}
+
+	if idToken.AccessTokenHash != "" {
+		err = idToken.VerifyAccessToken(token.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("verify id token: %w", err)
+		}
+	}
+
+	var raw json.RawMessage
+
+	err = idToken.Claims(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeClaims(raw)
+}
+
+// userInfoClaims fetches the claims from the OIDC userinfo endpoint.
+func (p *Provider) userInfoClaims(ctx context.Context, token *oauth2.Token) (map[string]any, error) {
+	userInfo, err := p.OICDProvider.UserInfo(ctx, p.config.TokenSource(ctx, token))
+	if err != nil {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+
+	err = userInfo.Claims(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeClaims(raw)
+}
+
+// genericIdentity maps the response of a plain JSON userinfo endpoint.
+func (p *Provider) genericIdentity(ctx context.Context, token *oauth2.Token) (*identity, error) {
+	var claims map[string]any
+
+	err := p.getJSON(ctx, token, p.userInfoURL, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.claims.identity(claims)
+}
+
+// getJSON requests url with the token and decodes the JSON response into v.
+func (p *Provider) getJSON(ctx context.Context, token *oauth2.Token, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.config.Client(ctx, token).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", url, res.Status)
+	}
+
+	dec := json.NewDecoder(res.Body)
+	// keeps numeric ids exact
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
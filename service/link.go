@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// beginLink handles /auth/link/:provider: like beginLogin, but the identity is attached to the user of the refresh cookie
+// instead of logging in.
+func (s *Service) beginLink(c *gin.Context, provider *Provider) {
+	redirectURI, errorURI, ok := s.checkRedirectTargets(c)
+	if !ok {
+		return
+	}
+
+	rs, rerr := s.refreshCookie(c)
+	if rerr != nil {
+		target := errorURI
+		if target == "" {
+			target = redirectURI
+		}
+
+		if target != "" {
+			c.Redirect(http.StatusFound, withLoginError(target, loginErrLoginRequired))
+			return
+		}
+
+		writeErrorPage(c, http.StatusUnauthorized, "You have to be logged in to link another account.")
+		return
+	}
+
+	s.beginFlow(c, provider, &authState{
+		RedirectURI: redirectURI,
+		ErrorURI:    errorURI,
+		LinkUserID:  rs.user.ID,
+	})
+}
+
+// finishLink completes a link flow by attaching the identity to the user that started it.
+func (s *Service) finishLink(c *gin.Context, provider *Provider, st *authState, subject string, email string, emailVerified bool) {
+	user, err := s.db.GetUser(uint32(st.LinkUserID))
+	if err != nil {
+		s.log.Info("get user error", "error", err)
+		s.failLogin(c, st, http.StatusUnauthorized, loginErrLoginRequired, "The account to link to does not exist anymore.")
+		return
+	}
+
+	auth, err := s.db.TryGetAuthentication(provider.Name, subject)
+
+	if err == nil {
+		if auth.UserID != user.ID {
+			s.log.Info("identity already linked to another user", "provider", provider.Name, "user", user.ID, "owner", auth.UserID)
+			s.failLogin(c, st, http.StatusConflict, loginErrAlreadyLinked, "This account is already linked to another user.")
+			return
+		}
+
+		// linked already, nothing to do
+		s.completeFlow(c, st)
+		return
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.log.Info("get auth error", "error", err)
+		s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+		return
+	}
+
+	auth, err = s.db.CreateAuthenticationFor(user)
+	if err != nil {
+		s.log.Info("create auth error", "error", err)
+		s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+		return
+	}
+
+	auth.Provider = provider.Name
+	auth.Subject = subject
+	auth.Email = email
+	auth.EmailVerified = emailVerified
+	err = s.db.UpdateAuthentication(auth)
+	if err != nil {
+		s.log.Info("update auth error", "error", err)
+		s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+		return
+	}
+
+	s.log.Info("linked identity", "provider", provider.Name, "user", user.ID)
+
+	s.completeFlow(c, st)
+}
+
+// userForVerifiedEmail finds the user to attach a new identity to by its email. This only happens if the provider of the
+// identity is trusted with emails, and only identities of trusted providers are considered. Returns nil if there is no
+// such user, or if the email is ambiguous.
+func (s *Service) userForVerifiedEmail(provider *Provider, email string, verified bool) (*db.User, error) {
+	if !provider.trustEmail || !verified || email == "" {
+		return nil, nil
+	}
+
+	trusted := make([]string, 0)
+	for _, p := range s.config.OAuthProviders {
+		if p.TrustEmailForLinking {
+			trusted = append(trusted, p.Name)
+		}
+	}
+
+	auths, err := s.db.FindVerifiedAuthentications(email, trusted)
+	if err != nil || len(auths) == 0 {
+		return nil, err
+	}
+
+	for _, auth := range auths[1:] {
+		if auth.UserID != auths[0].UserID {
+			s.log.Warn("verified email belongs to multiple users, not linking", "provider", provider.Name)
+			return nil, nil
+		}
+	}
+
+	user, err := s.db.GetUserFor(&auths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("linking identity by verified email", "provider", provider.Name, "user", user.ID)
+
+	return user, nil
+}
+
+// identities handles GET /auth/identities: it lists the identities linked to the user of the refresh cookie.
+func (s *Service) identities(c *gin.Context) {
+	rs, rerr := s.refreshCookie(c)
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	list := make([]core.Authentication, 0, len(rs.user.Authentications))
+	for _, auth := range rs.user.Authentications {
+		list = append(list, core.Authentication{
+			Provider: auth.Provider,
+			Subject:  auth.Subject,
+			Email:    auth.Email,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"identities": list,
+	})
+}
+
+// unlink handles DELETE /auth/link/:provider: it removes the identities of a provider (or only the one with the given
+// subject) from the user of the refresh cookie. The last identity of a user can't be removed.
+func (s *Service) unlink(c *gin.Context) {
+	rs, rerr := s.refreshCookie(c)
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	provider := c.Param("provider")
+	removed, err := s.db.DeleteAuthentications(rs.user.ID, provider, c.Query("subject"))
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "identity not linked",
+		})
+		return
+	}
+
+	if errors.Is(err, core.ErrLastAuthentication) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "cannot unlink the last identity",
+		})
+		return
+	}
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("delete auth error", "error", err)
+		return
+	}
+
+	s.log.Info("unlinked identity", "provider", provider, "user", rs.user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"removed": removed,
+	})
+}
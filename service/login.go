@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var closeWindowPage = []byte("<html><script>window.close();</script><body><h1>Authentication is complete.</h1>You may now close this window/tab.</body></html>")
+
+const errorPageTemplate = "<html><body><h1>Authentication failed.</h1>%s</body></html>"
+
+// writeErrorPage ends a browser-facing flow with a simple error page.
+func writeErrorPage(c *gin.Context, status int, message string) {
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.WriteHeader(status)
+	_, _ = fmt.Fprintf(c.Writer, errorPageTemplate, html.EscapeString(message))
+}
+
+// failLogin ends a failed login. If the login was started with an error_uri or redirect_uri, the browser is sent there
+// with the error code as "error" query parameter. Otherwise, an error page is shown.
+func (s *Service) failLogin(c *gin.Context, st *authState, status int, code string, message string) {
+	if st != nil {
+		target := st.ErrorURI
+		if target == "" {
+			target = st.RedirectURI
+		}
+
+		if target != "" {
+			c.Redirect(http.StatusFound, withLoginError(target, code))
+			return
+		}
+	}
+
+	writeErrorPage(c, status, message)
+}
+
+// checkRedirectTargets validates the redirect_uri and error_uri query parameters against the allow-list.
+// On failure, an error page is written and false is returned.
+func (s *Service) checkRedirectTargets(c *gin.Context) (redirectURI string, errorURI string, ok bool) {
+	redirectURI = c.Query("redirect_uri")
+	errorURI = c.Query("error_uri")
+
+	for _, target := range []string{redirectURI, errorURI} {
+		if target != "" && !s.redirectAllowed(target) {
+			s.log.Info("rejected redirect target", "target", target, "ip", c.ClientIP())
+			writeErrorPage(c, http.StatusBadRequest, "The requested redirect target is not allowed.")
+			return "", "", false
+		}
+	}
+
+	return redirectURI, errorURI, true
+}
+
+// beginLogin handles /auth/begin/:provider: it stores the login state and sends the browser to the provider.
+// With a user_code, the login approves a device instead (see /device).
+func (s *Service) beginLogin(c *gin.Context, provider *Provider) {
+	redirectURI, errorURI, ok := s.checkRedirectTargets(c)
+	if !ok {
+		return
+	}
+
+	st := &authState{
+		RedirectURI: redirectURI,
+		ErrorURI:    errorURI,
+	}
+
+	if userCode := c.Query("user_code"); userCode != "" {
+		st.DeviceUserCode, ok = s.checkUserCode(c, userCode)
+		if !ok {
+			return
+		}
+	}
+
+	s.beginFlow(c, provider, st)
+}
+
+// beginFlow stores the state of a login, link or device flow and sends the browser to the provider.
+func (s *Service) beginFlow(c *gin.Context, provider *Provider, st *authState) {
+	err := s.beginAuthState(c, provider, st)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create state error", "error", err)
+		return
+	}
+
+	// redirect
+	url := provider.authCodeURL(st)
+	c.Redirect(http.StatusFound, url)
+}
+
+// finishLogin handles /auth/end/:provider: it completes the OAuth flow, finds or creates the user and issues the refresh token
+// (or approves the device the login was started for).
+func (s *Service) finishLogin(c *gin.Context, provider *Provider) {
+	st, err := s.consumeAuthState(c, provider.Name)
+	if err != nil {
+		s.log.Info("state error", "provider", provider.Name, "error", err, "ip", c.ClientIP())
+		s.failLogin(c, st, http.StatusBadRequest, loginErrInvalidState, "The login request could not be verified. It may have expired or been started in another browser. Please try to log in again.")
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		s.log.Info("provider returned error", "provider", provider.Name, "error", providerErr, "description", c.Query("error_description"))
+
+		if st.DeviceUserCode != "" {
+			err = s.db.DenyDeviceCode(st.DeviceUserCode)
+			if err != nil {
+				s.log.Info("deny device code error", "error", err)
+			}
+		}
+
+		s.failLogin(c, st, http.StatusUnauthorized, loginErrAccessDenied, "The login was cancelled or denied by the provider.")
+		return
+	}
+
+	code := c.Query("code")
+	token, err := provider.exchange(context.Background(), code, st)
+
+	if err != nil {
+		s.log.Info("exchange error", "error", err)
+		s.failLogin(c, st, http.StatusBadGateway, loginErrProvider, "The provider did not accept the login.")
+		return
+	}
+
+	userInfo, err := provider.identity(context.Background(), token, st.Nonce)
+	if err != nil {
+		s.log.Info("identity error", "provider", provider.Name, "error", err)
+		s.failLogin(c, st, http.StatusBadGateway, loginErrProvider, "The user info could not be fetched from the provider.")
+		return
+	}
+
+	if st.LinkUserID != 0 {
+		s.finishLink(c, provider, st, userInfo.Subject, userInfo.Email, userInfo.EmailVerified)
+		return
+	}
+
+	// try get auth
+	auth, err := s.db.TryGetAuthentication(provider.Name, userInfo.Subject)
+
+	if err != nil {
+		// new identity: attach to a user with the same verified email, if trusted - otherwise it's a new user!
+		usr, err := s.userForVerifiedEmail(provider, userInfo.Email, userInfo.EmailVerified)
+		if err != nil {
+			s.log.Info("find user by email error", "error", err)
+			s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+			return
+		}
+
+		if usr == nil {
+			usr, err = s.db.CreateUser()
+			if err != nil {
+				s.log.Info("create user error", "error", err)
+				s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+				return
+			}
+		}
+
+		auth, err = s.db.CreateAuthenticationFor(usr)
+		if err != nil {
+			s.log.Info("create auth error", "error", err)
+			s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+			return
+		}
+
+		auth.Provider = provider.Name
+		auth.Subject = userInfo.Subject
+		auth.Email = userInfo.Email
+		auth.EmailVerified = userInfo.EmailVerified
+		err = s.db.UpdateAuthentication(auth)
+		if err != nil {
+			s.log.Info("update auth error", "error", err)
+			s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+			return
+		}
+	} else if auth.Email != userInfo.Email || auth.EmailVerified != userInfo.EmailVerified {
+		// keep the email up to date, so matching by email uses the current state
+		auth.Email = userInfo.Email
+		auth.EmailVerified = userInfo.EmailVerified
+		err = s.db.UpdateAuthentication(auth)
+		if err != nil {
+			s.log.Info("update auth error", "error", err)
+		}
+	}
+
+	user, err := s.db.GetUserFor(auth)
+
+	if err != nil {
+		s.log.Info("get user error", "error", err)
+		s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+		return
+	}
+
+	err = provider.syncClaims(user, userInfo)
+	if err != nil {
+		s.log.Info("sync claims error", "provider", provider.Name, "error", err)
+	}
+
+	err = s.syncRoles(provider, user, userInfo)
+	if err != nil {
+		s.log.Info("sync roles error", "provider", provider.Name, "error", err)
+	}
+
+	// persists the synced userdata too
+	user.LastLogin = time.Now()
+	err = s.db.UpdateUser(user)
+	if err != nil {
+		s.log.Info("update user error", "error", err)
+	}
+
+	if st.DeviceUserCode != "" {
+		s.finishDeviceLogin(c, st, user)
+		return
+	}
+
+	j, err := s.createRefreshTokenFor(user)
+	if err != nil {
+		s.log.Info("create jwt error", "error", err)
+		s.failLogin(c, st, http.StatusInternalServerError, loginErrServer, "Something went wrong on our side.")
+		return
+	}
+
+	s.setRefreshCookie(c, j)
+
+	s.completeFlow(c, st)
+}
+
+// completeFlow sends the browser to the redirect_uri of a successful flow, or shows the close window page.
+func (s *Service) completeFlow(c *gin.Context, st *authState) {
+	if st.RedirectURI != "" {
+		c.Redirect(http.StatusFound, st.RedirectURI)
+		return
+	}
+
+	// write close window page
+
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.Header().Set("Pragma", "no-cache")
+	c.Writer.Header().Set("Expires", "0")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	_, _ = c.Writer.Write(closeWindowPage)
+}
@@ -0,0 +1,466 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// scopes kingdom-auth understands as OpenID Connect provider
+var supportedScopes = []string{"openid", "profile", "email", "roles"}
+
+var oidcLoginPage = template.Must(template.New("login").Parse(`<html><body>
+<h1>Log in to {{.Client}}</h1>
+<p>Log in with:</p>
+<ul>
+{{range .Providers}}<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{end}}</ul>
+</body></html>`))
+
+// authorizeURL is the URL of the /authorize endpoint.
+func (s *Service) authorizeURL() string {
+	return s.config.MainService.PublicUrl + "/authorize"
+}
+
+// authorizeRedirect appends the given parameters to the redirect_uri of a client.
+func (s *Service) authorizeRedirect(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	// tells the client which provider answered (RFC 9207)
+	q.Set("iss", s.config.Token.Issuer)
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// authorize handles GET /authorize: the authorization code flow of OpenID Connect. If the browser has a valid refresh
+// token, the client gets a code right away. Otherwise, the user logs in with one of the providers first.
+func (s *Service) authorize(c *gin.Context) {
+	q := c.Request.URL.Query()
+
+	// without a known client and redirect_uri, errors can't be sent back to the client
+	client, ok := s.findClient(q.Get("client_id"))
+	if !ok {
+		writeErrorPage(c, http.StatusBadRequest, "The application is not registered.")
+		return
+	}
+
+	redirectURI := q.Get("redirect_uri")
+	if !redirectURIAllowed(client, redirectURI) {
+		s.log.Info("rejected client redirect_uri", "client", client.ID, "redirect_uri", redirectURI)
+		writeErrorPage(c, http.StatusBadRequest, "The redirect target of the application is not registered.")
+		return
+	}
+
+	state := q.Get("state")
+
+	fail := func(code string, description string) {
+		c.Redirect(http.StatusFound, s.authorizeRedirect(redirectURI, map[string]string{
+			"error":             code,
+			"error_description": description,
+			"state":             state,
+		}))
+	}
+
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the authorization code flow is supported")
+		return
+	}
+
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(q.Get("scope")) {
+		if slices.Contains(supportedScopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if !slices.Contains(scopes, "openid") {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+
+	challenge := q.Get("code_challenge")
+
+	if challenge != "" && q.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "only the S256 code challenge method is supported")
+		return
+	}
+
+	if challenge == "" && client.SecretHash == "" {
+		fail("invalid_request", "public clients have to use PKCE")
+		return
+	}
+
+	prompt := strings.Fields(q.Get("prompt"))
+
+	rs, rerr := s.refreshCookie(c)
+
+	if rerr != nil || slices.Contains(prompt, "login") {
+		if slices.Contains(prompt, "none") {
+			fail("login_required", "")
+			return
+		}
+
+		s.writeOIDCLoginPage(c, client, q)
+		return
+	}
+
+	code, err := randomString(32)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create authorization code error", "error", err)
+		return
+	}
+
+	err = s.db.CreateAuthorizationCode(&db.AuthorizationCode{
+		CodeHash:      hashCode(code),
+		ClientID:      client.ID,
+		UserID:        rs.user.ID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: challenge,
+		ExpiresAt:     time.Now().Add(time.Second * time.Duration(s.config.OIDC.CodeTTL)),
+	})
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create authorization code error", "error", err)
+		return
+	}
+
+	s.log.Info("authorized client", "client", client.ID, "user", rs.user.ID)
+
+	c.Redirect(http.StatusFound, s.authorizeRedirect(redirectURI, map[string]string{
+		"code":  code,
+		"state": state,
+	}))
+}
+
+// writeOIDCLoginPage lets the user pick a provider to log in with. After the login, the browser comes back to /authorize.
+func (s *Service) writeOIDCLoginPage(c *gin.Context, client *config.ClientConfig, q url.Values) {
+	// asking for a login again after the login would never end
+	q.Del("prompt")
+	continuation := s.authorizeURL() + "?" + q.Encode()
+
+	type providerLink struct {
+		Name string
+		URL  string
+	}
+
+	providers := make([]providerLink, 0, len(s.config.OAuthProviders))
+	for _, p := range s.config.OAuthProviders {
+		providers = append(providers, providerLink{
+			Name: p.Name,
+			URL:  s.config.MainService.PublicUrl + "/auth/begin/" + url.PathEscape(p.Name) + "?redirect_uri=" + url.QueryEscape(continuation),
+		})
+	}
+
+	name := client.Name
+	if name == "" {
+		name = client.ID
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	err := oidcLoginPage.Execute(c.Writer, gin.H{
+		"Client":    name,
+		"Providers": providers,
+	})
+	if err != nil {
+		s.log.Info("render login page error", "error", err)
+	}
+}
+
+// oauthToken handles POST /oauth/token, the token endpoint for OpenID Connect and service clients.
+func (s *Service) oauthToken(c *gin.Context) {
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		s.authorizationCodeGrant(c)
+	case "client_credentials":
+		s.clientCredentialsGrant(c)
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// rejectClient answers a token request of a client that couldn't be authenticated.
+func rejectClient(c *gin.Context) {
+	if _, _, basic := c.Request.BasicAuth(); basic {
+		c.Header("WWW-Authenticate", `Basic realm="kingdom-auth"`)
+	}
+
+	oauthError(c, http.StatusUnauthorized, "invalid_client", "")
+}
+
+// invalidGrant is the reason an authorization code can't be redeemed by a request.
+type invalidGrant string
+
+func (e invalidGrant) Error() string {
+	return string(e)
+}
+
+// checkAuthorizationCode checks that the client may redeem the code with the redirect_uri and code_verifier of its request.
+func checkAuthorizationCode(code *db.AuthorizationCode, client *config.ClientConfig, redirectURI string, verifier string) error {
+	if code.ClientID != client.ID || code.RedirectURI != redirectURI || !code.ExpiresAt.After(time.Now()) {
+		return invalidGrant("the code is expired or was issued for another client or redirect_uri")
+	}
+
+	if code.CodeChallenge == "" {
+		// a verifier without a challenge means the challenge got lost on the way, e.g. stripped by an attacker
+		if verifier != "" {
+			return invalidGrant("code_verifier given, but the authorization request had no code_challenge")
+		}
+
+		return nil
+	}
+
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(oauth2.S256ChallengeFromVerifier(verifier)), []byte(code.CodeChallenge)) != 1 {
+		return invalidGrant("code_verifier doesn't match")
+	}
+
+	return nil
+}
+
+// authorizationCodeGrant redeems an authorization code for an access token and an ID token.
+func (s *Service) authorizationCodeGrant(c *gin.Context) {
+	client, ok := s.authenticateClient(c)
+	if !ok {
+		rejectClient(c)
+		return
+	}
+
+	// the code is only used up by a request that may redeem it - anyone else who learned it can't burn it
+	code, err := s.db.RedeemAuthorizationCode(hashCode(c.PostForm("code")), func(code *db.AuthorizationCode) error {
+		return checkAuthorizationCode(code, client, c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	})
+
+	var rejected invalidGrant
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "unknown code")
+		return
+	}
+
+	if errors.As(err, &rejected) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", string(rejected))
+		return
+	}
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("redeem authorization code error", "error", err)
+		return
+	}
+
+	user, err := s.db.GetUser(uint32(code.UserID))
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "user not found")
+		return
+	}
+
+	scopes := strings.Fields(code.Scope)
+	exp := time.Now().Add(time.Second * time.Duration(s.config.OIDC.TokenTTL))
+
+	accessToken, err := s.sign(jwt.MapClaims{
+		"sub":                        fmt.Sprintf("%d", user.ID),
+		"aud":                        client.ID,
+		"iss":                        s.config.Token.Issuer,
+		"exp":                        exp.Unix(),
+		"iat":                        time.Now().Unix(),
+		core.ClientIDClaim:           client.ID,
+		core.ScopeClaim:              code.Scope,
+		core.TokenUseClaim:           core.TokenUseOIDCAccess,
+		core.KingdomAuthVersionClaim: core.KingdomAuthVersion,
+	})
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	idClaims, err := s.userClaims(user, scopes)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("user claims error", "error", err)
+		return
+	}
+
+	idClaims["iss"] = s.config.Token.Issuer
+	idClaims["aud"] = client.ID
+	idClaims["exp"] = exp.Unix()
+	idClaims["iat"] = time.Now().Unix()
+	idClaims["auth_time"] = user.LastLogin.Unix()
+	idClaims[core.TokenUseClaim] = core.TokenUseOIDCID
+
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+
+	idToken, err := s.sign(idClaims)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   s.config.OIDC.TokenTTL,
+		"id_token":     idToken,
+		"scope":        code.Scope,
+	})
+}
+
+// firstString returns the first non-empty string value of the given userdata keys.
+func firstString(ud db.UserData, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := ud[key].(string); ok && v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// userClaims returns the OpenID Connect claims of a user for the granted scopes. Profile claims come from the public userdata.
+func (s *Service) userClaims(user *db.User, scopes []string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{
+		"sub": fmt.Sprintf("%d", user.ID),
+	}
+
+	if slices.Contains(scopes, "profile") {
+		pub, err := user.GetPublicUserdata()
+		if err != nil {
+			return nil, err
+		}
+
+		profile := map[string]string{
+			"name":               firstString(pub, "name", "display_name"),
+			"preferred_username": firstString(pub, "preferred_username", "username"),
+			"picture":            firstString(pub, "picture", "avatar"),
+			"locale":             firstString(pub, "locale"),
+		}
+
+		for k, v := range profile {
+			if v != "" {
+				claims[k] = v
+			}
+		}
+	}
+
+	if slices.Contains(scopes, "email") {
+		// prefer verified emails
+		for _, auth := range user.Authentications {
+			if auth.Email == "" {
+				continue
+			}
+
+			if _, ok := claims["email"]; !ok || auth.EmailVerified {
+				claims["email"] = auth.Email
+				claims["email_verified"] = auth.EmailVerified
+			}
+
+			if auth.EmailVerified {
+				break
+			}
+		}
+	}
+
+	if slices.Contains(scopes, "roles") {
+		roles, permissions, err := s.db.RolesAndPermissions(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		claims[core.RolesClaim] = roles
+		claims[core.PermissionsClaim] = permissions
+	}
+
+	return claims, nil
+}
+
+// userinfo handles GET/POST /userinfo: it returns the claims of the user an access token was issued for.
+func (s *Service) userinfo(c *gin.Context) {
+	raw, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	invalid := func() {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid_token",
+		})
+	}
+
+	if !found {
+		invalid()
+		return
+	}
+
+	tk, err := s.readAuthToken(strings.TrimSpace(raw))
+	if err != nil {
+		invalid()
+		return
+	}
+
+	// only access tokens issued to OpenID Connect clients - tokens of service clients have no user
+	clientID, _ := tk[core.ClientIDClaim].(string)
+	scope, _ := tk[core.ScopeClaim].(string)
+	iss, _ := tk.GetIssuer()
+
+	if clientID == "" || tk[core.TokenUseClaim] != core.TokenUseOIDCAccess || iss != s.config.Token.Issuer || tk[core.KingdomAuthVersionClaim] != core.KingdomAuthVersion {
+		invalid()
+		return
+	}
+
+	sub, _ := tk.GetSubject()
+
+	var uid uint32
+	_, err = fmt.Sscanf(sub, "%d", &uid)
+	if err != nil {
+		invalid()
+		return
+	}
+
+	user, err := s.db.GetUser(uid)
+	if err != nil {
+		invalid()
+		return
+	}
+
+	claims, err := s.userClaims(user, strings.Fields(scope))
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("user claims error", "error", err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, claims)
+}
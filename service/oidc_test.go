@@ -0,0 +1,159 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+func TestAuthorizationCodeGrantKeepsRejectedCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+	s.config.OIDC.Clients = []config.ClientConfig{
+		{ID: "spa", RedirectURIs: []string{"https://spa.example.com/cb"}},
+		{ID: "other", RedirectURIs: []string{"https://other.example.com/cb"}},
+	}
+
+	user, err := s.db.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/oauth/token", s.oauthToken)
+
+	verifier := oauth2.GenerateVerifier()
+
+	for _, code := range []*db.AuthorizationCode{
+		{CodeHash: hashCode("pkce"), CodeChallenge: oauth2.S256ChallengeFromVerifier(verifier)},
+		{CodeHash: hashCode("plain")},
+	} {
+		code.ClientID = "spa"
+		code.UserID = user.ID
+		code.RedirectURI = "https://spa.example.com/cb"
+		code.Scope = "openid"
+		code.ExpiresAt = time.Now().Add(time.Minute)
+
+		if err := s.db.CreateAuthorizationCode(code); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{
+			name:   "another client",
+			form:   url.Values{"client_id": {"other"}, "code": {"pkce"}, "redirect_uri": {"https://other.example.com/cb"}, "code_verifier": {verifier}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "another redirect_uri",
+			form:   url.Values{"client_id": {"spa"}, "code": {"pkce"}, "redirect_uri": {"https://spa.example.com/other"}, "code_verifier": {verifier}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "wrong verifier",
+			form:   url.Values{"client_id": {"spa"}, "code": {"pkce"}, "redirect_uri": {"https://spa.example.com/cb"}, "code_verifier": {oauth2.GenerateVerifier()}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "verifier without challenge",
+			form:   url.Values{"client_id": {"spa"}, "code": {"plain"}, "redirect_uri": {"https://spa.example.com/cb"}, "code_verifier": {verifier}},
+			status: http.StatusBadRequest,
+		},
+		// the rejected requests didn't use up the code
+		{
+			name:   "valid",
+			form:   url.Values{"client_id": {"spa"}, "code": {"pkce"}, "redirect_uri": {"https://spa.example.com/cb"}, "code_verifier": {verifier}},
+			status: http.StatusOK,
+		},
+		{
+			name:   "redeemed already",
+			form:   url.Values{"client_id": {"spa"}, "code": {"pkce"}, "redirect_uri": {"https://spa.example.com/cb"}, "code_verifier": {verifier}},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Set("grant_type", "authorization_code")
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("POST /oauth/token = %d %s, want %d", w.Code, w.Body.String(), tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				var body map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+
+				if body["error"] != "invalid_grant" {
+					t.Errorf("error = %v, want invalid_grant", body["error"])
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateClientComparesSecretHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.config.OIDC.Clients = []config.ClientConfig{
+		{ID: "app", SecretHash: string(hash)},
+		{ID: "spa"},
+	}
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		wantOK bool
+	}{
+		{"confidential", url.Values{"client_id": {"app"}, "client_secret": {"s3cret"}}, true},
+		{"wrong secret", url.Values{"client_id": {"app"}, "client_secret": {"wrong"}}, false},
+		{"hash as secret", url.Values{"client_id": {"app"}, "client_secret": {string(hash)}}, false},
+		{"no secret", url.Values{"client_id": {"app"}}, false},
+		{"public", url.Values{"client_id": {"spa"}}, true},
+		{"public with secret", url.Values{"client_id": {"spa"}, "client_secret": {"s3cret"}}, false},
+		{"unknown client", url.Values{"client_id": {"nope"}, "client_secret": {"s3cret"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+
+			if _, ok := s.authenticateClient(c); ok != tt.wantOK {
+				t.Errorf("authenticateClient() = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
@@ -2,16 +2,103 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"slices"
 
 	"github.com/5000K/kingdom-auth/config"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 )
 
+// supported provider types
+const (
+	providerTypeOIDC    = "oidc"
+	providerTypeGitHub  = "github"
+	providerTypeGeneric = "oauth2-generic"
+)
+
 type Provider struct {
 	Name         string
+	kind         string
 	config       oauth2.Config
 	OICDProvider *oidc.Provider
+
+	// userinfo endpoint of non-OIDC providers
+	userInfoURL string
+
+	claims *claimMapping
+	sync   []claimSync
+
+	groupMappings []groupMapping
+
+	// verifies ID tokens, nil if the provider's keys are unknown
+	verifier *oidc.IDTokenVerifier
+
+	usePKCE bool
+
+	// whether verified emails of this provider may be used to attach to existing users
+	trustEmail bool
+}
+
+// resolvePKCE decides whether PKCE is used for a provider. In auto mode, the discovery document decides (if there is one).
+func resolvePKCE(mode string, discovered *oidc.Provider) (bool, error) {
+	switch mode {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "", "auto":
+		if discovered == nil {
+			return false, nil
+		}
+
+		var claims struct {
+			CodeChallengeMethods []string `json:"code_challenge_methods_supported"`
+		}
+
+		err := discovered.Claims(&claims)
+		if err != nil {
+			return false, err
+		}
+
+		return slices.Contains(claims.CodeChallengeMethods, "S256"), nil
+	default:
+		return false, fmt.Errorf("invalid pkce mode %q (supported: true, false, auto)", mode)
+	}
+}
+
+// newProvider creates a provider with the settings all provider types share: PKCE, claim mapping, claim sync and group mappings.
+// discovered is the discovery document for the auto PKCE mode (nil if there is none), defaults are the claims of the provider type.
+func newProvider(cfg *config.OAuthConfig, kind string, discovered *oidc.Provider, defaults config.ClaimsConfig) (*Provider, error) {
+	usePKCE, err := resolvePKCE(cfg.PKCE, discovered)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := newClaimMapping(cfg.Claims, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	sync, err := newClaimSyncs(cfg.SyncClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	groupMappings, err := newGroupMappings(cfg.GroupMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		Name:          cfg.Name,
+		kind:          kind,
+		claims:        claims,
+		sync:          sync,
+		groupMappings: groupMappings,
+		usePKCE:       usePKCE,
+		trustEmail:    cfg.TrustEmailForLinking,
+	}, nil
 }
 
 func createProviderManually(config *config.OAuthConfig, redirectUrl string) (*Provider, error) {
@@ -23,24 +110,78 @@ func createProviderManually(config *config.OAuthConfig, redirectUrl string) (*Pr
 		UserInfoURL:   config.Endpoints.UserInfoURL,
 		JWKSURL:       config.Endpoints.JWKSURL,
 		DeviceAuthURL: config.Endpoints.DeviceAuthURL,
+		// there is no discovery document announcing the algorithms, so accept all asymmetric ones
+		Algorithms: []string{
+			oidc.RS256, oidc.RS384, oidc.RS512,
+			oidc.ES256, oidc.ES384, oidc.ES512,
+			oidc.PS256, oidc.PS384, oidc.PS512,
+			oidc.EdDSA,
+		},
 	}
 
 	p := c.NewProvider(context.Background())
 
-	return &Provider{
-		Name: config.Name,
-		config: oauth2.Config{
-			Scopes:       config.Scopes,
-			ClientID:     config.ClientId,
-			ClientSecret: config.ClientSecret,
-			Endpoint:     p.Endpoint(),
-			RedirectURL:  redirectUrl,
+	// without the provider's keys, ID tokens can't be verified - only userinfo is used then
+	var verifier *oidc.IDTokenVerifier
+	if config.Endpoints.JWKSURL != "" {
+		verifier = p.Verifier(&oidc.Config{ClientID: config.ClientId})
+	}
+
+	prov, err := newProvider(config, providerTypeOIDC, nil, defaultClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	prov.config = oauth2.Config{
+		Scopes:       withOpenIDScope(config.Scopes),
+		ClientID:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		Endpoint:     p.Endpoint(),
+		RedirectURL:  redirectUrl,
+	}
+	prov.OICDProvider = p
+	prov.verifier = verifier
+
+	return prov, nil
+}
+
+// createGenericProvider creates a provider for plain OAuth2 with a JSON userinfo endpoint. All endpoints have to be configured.
+func createGenericProvider(config *config.OAuthConfig, redirectUrl string) (*Provider, error) {
+	if config.Endpoints.AuthURL == "" || config.Endpoints.TokenURL == "" || config.Endpoints.UserInfoURL == "" {
+		return nil, fmt.Errorf("provider type %s needs the auth, token and user_info endpoints", providerTypeGeneric)
+	}
+
+	prov, err := newProvider(config, providerTypeGeneric, nil, defaultClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	prov.config = oauth2.Config{
+		Scopes:       config.Scopes,
+		ClientID:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  config.Endpoints.AuthURL,
+			TokenURL: config.Endpoints.TokenURL,
 		},
-		OICDProvider: p,
-	}, nil
+		RedirectURL: redirectUrl,
+	}
+	prov.userInfoURL = config.Endpoints.UserInfoURL
+
+	return prov, nil
 }
 
 func NewProvider(config *config.OAuthConfig, redirectUrl string) (*Provider, error) {
+	switch config.Type {
+	case "", providerTypeOIDC:
+	case providerTypeGitHub:
+		return createGitHubProvider(config, redirectUrl)
+	case providerTypeGeneric:
+		return createGenericProvider(config, redirectUrl)
+	default:
+		return nil, fmt.Errorf("invalid provider type %q (supported: %s, %s, %s)", config.Type, providerTypeOIDC, providerTypeGitHub, providerTypeGeneric)
+	}
+
 	// Support for manual OIDC discovery URL
 	if config.SkipDiscovery {
 		return createProviderManually(config, redirectUrl)
@@ -54,33 +195,55 @@ func NewProvider(config *config.OAuthConfig, redirectUrl string) (*Provider, err
 		return nil, err
 	}
 
-	scopes := config.Scopes
+	prov, err := newProvider(config, providerTypeOIDC, oProv, defaultClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	prov.config = oauth2.Config{
+		Scopes:       withOpenIDScope(config.Scopes),
+		ClientID:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		Endpoint:     oProv.Endpoint(),
+		RedirectURL:  redirectUrl,
+	}
+	prov.OICDProvider = oProv
+	prov.verifier = oProv.Verifier(&oidc.Config{ClientID: config.ClientId})
 
-	hasOICDScope := false
+	return prov, nil
+}
 
-	for _, scope := range config.Scopes {
-		if scope == oidc.ScopeOpenID {
-			hasOICDScope = true
-		}
+// withOpenIDScope adds the openid scope, which OIDC providers need to issue ID tokens.
+func withOpenIDScope(scopes []string) []string {
+	if slices.Contains(scopes, oidc.ScopeOpenID) {
+		return scopes
 	}
 
-	if !hasOICDScope {
-		scopes = append(scopes, oidc.ScopeOpenID)
+	return append(slices.Clone(scopes), oidc.ScopeOpenID)
+}
+
+// authCodeURL builds the URL of the provider's login page for the given login state.
+func (p *Provider) authCodeURL(st *authState) string {
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+
+	if st.Verifier != "" {
+		opts = append(opts, oauth2.S256ChallengeOption(st.Verifier))
 	}
 
-	return &Provider{
-		Name: config.Name,
-		config: oauth2.Config{
-			Scopes:       config.Scopes,
-			ClientID:     config.ClientId,
-			ClientSecret: config.ClientSecret,
-			Endpoint:     oProv.Endpoint(),
-			RedirectURL:  redirectUrl,
-		},
-		OICDProvider: oProv,
-	}, nil
+	if st.Nonce != "" {
+		opts = append(opts, oidc.Nonce(st.Nonce))
+	}
+
+	return p.config.AuthCodeURL(st.State, opts...)
 }
 
-func (p *Provider) getVerifier() *oidc.IDTokenVerifier {
-	return p.OICDProvider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+// exchange trades the code returned by the provider for a token.
+func (p *Provider) exchange(ctx context.Context, code string, st *authState) (*oauth2.Token, error) {
+	opts := make([]oauth2.AuthCodeOption, 0)
+
+	if st.Verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(st.Verifier))
+	}
+
+	return p.config.Exchange(ctx, code, opts...)
 }
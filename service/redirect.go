@@ -0,0 +1,115 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+)
+
+// error codes appended to the error redirect of a failed login
+const (
+	loginErrInvalidState = "invalid_state"
+	loginErrAccessDenied = "access_denied"
+	loginErrProvider     = "provider_error"
+	loginErrServer       = "server_error"
+
+	// link flows only
+	loginErrLoginRequired = "login_required"
+	loginErrAlreadyLinked = "already_linked"
+)
+
+// redirectAllowed checks whether the browser may be sent to target after a login.
+func (s *Service) redirectAllowed(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || !u.IsAbs() || u.User != nil || u.Host == "" {
+		return false
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+
+	// browsers resolve dot segments (also encoded ones) and treat backslashes like slashes after the path was matched,
+	// "/app/../admin" would pass an "/app/*" entry
+	if strings.Contains(u.Path, "\\") {
+		return false
+	}
+
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	// logins started by /authorize come back to it
+	if len(s.config.OIDC.Clients) > 0 && u.Scheme+"://"+u.Host+u.Path == s.authorizeURL() {
+		return true
+	}
+
+	for _, pattern := range s.config.Auth.RedirectAllowList {
+		if matchRedirect(pattern, u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchRedirect matches a target against a single allow-list entry.
+//
+// The scheme has to match exactly. The host matches exactly, or - if the pattern host starts with "*." - any subdomain of the rest.
+// The path matches exactly, or - if the pattern path ends with "*" - any path starting with the rest. Query and fragment are not compared.
+func matchRedirect(pattern string, target *url.URL) bool {
+	scheme, rest, ok := strings.Cut(pattern, "://")
+	if !ok {
+		return false
+	}
+
+	host, path, _ := strings.Cut(rest, "/")
+	path = "/" + path
+
+	// query and fragment of the pattern are ignored
+	path, _, _ = strings.Cut(path, "?")
+	path, _, _ = strings.Cut(path, "#")
+
+	if !strings.EqualFold(scheme, target.Scheme) {
+		return false
+	}
+
+	if !matchHost(strings.ToLower(host), strings.ToLower(target.Host)) {
+		return false
+	}
+
+	targetPath := target.EscapedPath()
+	if targetPath == "" {
+		targetPath = "/"
+	}
+
+	if prefix, ok := strings.CutSuffix(path, "*"); ok {
+		return strings.HasPrefix(targetPath, prefix)
+	}
+
+	return targetPath == path
+}
+
+func matchHost(pattern string, host string) bool {
+	suffix, ok := strings.CutPrefix(pattern, "*.")
+	if !ok {
+		return pattern == host
+	}
+
+	return strings.HasSuffix(host, "."+suffix) && len(host) > len(suffix)+1
+}
+
+// withLoginError appends the error code of a failed login to a redirect target.
+func withLoginError(target string, code string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	q := u.Query()
+	q.Set("error", code)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
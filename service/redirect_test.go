@@ -0,0 +1,109 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/5000K/kingdom-auth/config"
+)
+
+func TestRedirectAllowed(t *testing.T) {
+	s := &Service{config: &config.Config{}}
+	s.config.Auth.RedirectAllowList = []string{
+		"https://app.example.com/done",
+		"https://*.example.org/app/*",
+		"http://localhost:3000/*",
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		// exact entries
+		{"https://app.example.com/done", true},
+		{"https://app.example.com/done?next=1#top", true},
+		{"https://APP.example.com/done", true},
+		{"https://app.example.com/done/", false},
+		{"https://app.example.com/donex", false},
+		{"https://app.example.com/", false},
+		{"http://app.example.com/done", false},
+
+		// wildcard hosts
+		{"https://a.example.org/app/x", true},
+		{"https://a.b.example.org/app/x", true},
+		{"https://example.org/app/x", false},
+		{"https://evilexample.org/app/x", false},
+		{"https://a.example.org.evil.com/app/x", false},
+		{"https://a.example.org/application", false},
+		{"https://a.example.org/app", false},
+
+		// ports
+		{"https://app.example.com:443/done", false},
+		{"https://app.example.com:8443/done", false},
+		{"https://a.example.org:8443/app/x", false},
+		{"http://localhost:3000/cb", true},
+		{"http://localhost:3001/cb", false},
+		{"http://localhost/cb", false},
+
+		// userinfo and host confusion
+		{"https://app.example.com@evil.com/done", false},
+		{"https://user:pw@app.example.com/done", false},
+		{"https://evil.com#@app.example.com/done", false},
+		{"https://evil.com?@app.example.com/done", false},
+		{"https://evil.com\\@app.example.com/done", false},
+
+		// path prefix bypasses
+		{"https://a.example.org/app/../admin", false},
+		{"https://a.example.org/app/./x", false},
+		{"https://a.example.org/app/%2e%2e/admin", false},
+		{"https://a.example.org/app/%2E%2E/admin", false},
+		{"https://a.example.org/app/..", false},
+		{"https://a.example.org/app/..%2fadmin", false},
+		{"http://localhost:3000/../x", false},
+		{"https://a.example.org/app\\..\\admin", false},
+		{"https://a.example.org/app/%5c..%5cadmin", false},
+		{"https://a.example.org/app/x..y/z", true},
+
+		// no absolute http(s) url
+		{"/done", false},
+		{"//app.example.com/done", false},
+		{"javascript:alert(1)", false},
+		{"ftp://app.example.com/done", false},
+		{"https:///done", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := s.redirectAllowed(tt.target); got != tt.want {
+			t.Errorf("redirectAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectAllowedAuthorize(t *testing.T) {
+	s := &Service{config: &config.Config{}}
+	s.config.MainService.PublicUrl = "https://auth.example.com"
+
+	// only allowed if kingdom-auth acts as OpenID Connect provider
+	if s.redirectAllowed("https://auth.example.com/authorize?client_id=x") {
+		t.Error("/authorize allowed without clients")
+	}
+
+	s.config.OIDC.Clients = []config.ClientConfig{{ID: "x"}}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://auth.example.com/authorize?client_id=x", true},
+		{"https://auth.example.com/authorize/x", false},
+		{"https://auth.example.com/token", false},
+		{"http://auth.example.com/authorize", false},
+		{"https://auth.example.com.evil.com/authorize", false},
+	}
+
+	for _, tt := range tests {
+		if got := s.redirectAllowed(tt.target); got != tt.want {
+			t.Errorf("redirectAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
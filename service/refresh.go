@@ -0,0 +1,305 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// refreshedSession is the result of a successfully checked refresh token.
+type refreshedSession struct {
+	claims  jwt.MapClaims
+	session *db.Session
+	user    *db.User
+}
+
+// refreshError describes why a refresh token was rejected. A nil body means an internal error.
+type refreshError struct {
+	status int
+	body   gin.H
+}
+
+// write writes the error as JSON response.
+func (e *refreshError) write(c *gin.Context) {
+	if e.body == nil {
+		c.Writer.WriteHeader(e.status)
+		return
+	}
+
+	c.JSON(e.status, e.body)
+}
+
+func unauthorized(message string) *refreshError {
+	return &refreshError{
+		status: http.StatusUnauthorized,
+		body: gin.H{
+			"error": message,
+		},
+	}
+}
+
+var internalRefreshError = &refreshError{status: http.StatusInternalServerError}
+
+// checkRefreshToken validates a refresh token including its session and loads the user it belongs to.
+// Presenting a refresh token that was already rotated revokes its whole session family.
+func (s *Service) checkRefreshToken(c *gin.Context, raw string) (*refreshedSession, *refreshError) {
+	tk, err := s.readRefreshToken(raw)
+
+	if err != nil {
+		if errors.Is(err, core.ErrTokenExpired) {
+			return nil, unauthorized("token expired")
+		} else if errors.Is(err, core.ErrTokenInvalid) {
+			return nil, unauthorized("token invalid")
+		} else if errors.Is(err, core.ErrInvalidSignature) {
+			return nil, unauthorized("token signature invalid")
+		}
+		return nil, internalRefreshError
+	}
+
+	version := tk[core.KingdomAuthVersionClaim]
+
+	if version != core.KingdomAuthVersion {
+		return nil, &refreshError{
+			status: http.StatusUnauthorized,
+			body: gin.H{
+				"error":    "version mismatch: token is from another format (older or newer)",
+				"expected": core.KingdomAuthVersion,
+				"actual":   version,
+			},
+		}
+	}
+
+	iss, err := tk.GetIssuer()
+	if err != nil {
+		s.log.Info("get issuer error", "error", err)
+		return nil, internalRefreshError
+	}
+
+	if iss != s.config.Token.Issuer {
+		return nil, &refreshError{
+			status: http.StatusUnauthorized,
+			body: gin.H{
+				"error":    "issuer mismatch",
+				"expected": s.config.Token.Issuer,
+				"actual":   iss,
+			},
+		}
+	}
+
+	if tk[core.TokenUseClaim] != core.TokenUseRefresh {
+		return nil, unauthorized("token is no refresh token")
+	}
+
+	uidS, err := tk.GetSubject()
+
+	if err != nil {
+		s.log.Info("get subject error", "error", err)
+		return nil, internalRefreshError
+	}
+
+	uid, err := strconv.ParseUint(uidS, 10, 32)
+
+	if err != nil {
+		return nil, internalRefreshError
+	}
+
+	// a refresh token is only valid as long as its session is
+	jti, _ := tk["jti"].(string)
+	session, err := s.db.GetSession(jti)
+
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.log.Info("get session error", "error", err)
+		return nil, internalRefreshError
+	}
+
+	if jti == "" || err != nil || session.UserID != uint(uid) {
+		return nil, unauthorized("session revoked")
+	}
+
+	if session.RotatedAt != nil {
+		// a concurrent refresh won the rotation - not a reuse
+		if s.inReuseGrace(session) {
+			s.log.Debug("accepted rotated refresh token within grace", "user", session.UserID, "family", session.FamilyID)
+		} else {
+			s.revokeReusedFamily(session, c)
+			return nil, unauthorized("session revoked")
+		}
+	} else if !session.IsActive() {
+		return nil, unauthorized("session revoked")
+	}
+
+	// find user
+	user, err := s.db.GetUser(uint32(uid))
+
+	if err != nil {
+		return nil, unauthorized("token valid, but user not found")
+	}
+
+	return &refreshedSession{
+		claims:  tk,
+		session: session,
+		user:    user,
+	}, nil
+}
+
+// inReuseGrace reports whether a rotated session may still be used, because it was rotated just now and isn't revoked or expired.
+func (s *Service) inReuseGrace(session *db.Session) bool {
+	return session.RotatedAt != nil && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()) &&
+		time.Since(*session.RotatedAt) <= time.Second*time.Duration(s.config.Token.ReuseGrace)
+}
+
+// rotateIfDue rotates the refresh token if it is old enough. Returns the new refresh token, or an empty string if the
+// token was not rotated.
+func (s *Service) rotateIfDue(c *gin.Context, rs *refreshedSession) (string, *refreshError) {
+	// rotated already, the successor reaches the client with the response of the refresh that rotated it
+	if rs.session.RotatedAt != nil {
+		return "", nil
+	}
+
+	issueDate, err := rs.claims.GetIssuedAt()
+
+	if err != nil || issueDate == nil || clock().Sub(issueDate.Time) <= time.Second*time.Duration(s.config.Token.MinAgeForRefresh) {
+		return "", nil
+	}
+
+	j, err := s.rotateRefreshToken(rs.session)
+
+	// a concurrent refresh rotated the session between checking and rotating it
+	if errors.Is(err, core.ErrSessionReused) {
+		return "", nil
+	}
+
+	if err != nil {
+		s.log.Info("create jwt error", "error", err)
+		return "", internalRefreshError
+	}
+
+	return j, nil
+}
+
+// setRefreshCookie sends the refresh token as cookie. It lives as long as the refresh token, so the browser keeps sending it
+// until it is rotated.
+func (s *Service) setRefreshCookie(c *gin.Context, token string) {
+	c.SetCookie(s.config.CookieName, token, int(s.config.Token.RefreshTokenTTL), "/", s.config.CookieDomain, true, true)
+}
+
+// refreshCookie reads and checks the refresh token cookie.
+func (s *Service) refreshCookie(c *gin.Context) (*refreshedSession, *refreshError) {
+	cookieString, err := c.Cookie(s.config.CookieName)
+
+	if err != nil {
+		return nil, unauthorized("no token")
+	}
+
+	return s.checkRefreshToken(c, cookieString)
+}
+
+// writeAuthToken creates an auth token for the user and writes the response of /token. extra is added to the response.
+func (s *Service) writeAuthToken(c *gin.Context, user *db.User, extra gin.H) {
+	at, exp, err := s.createAuthTokenFor(user)
+
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("create jwt error", "error", err)
+		return
+	}
+
+	// search email from authentications
+
+	email := ""
+	for _, auth := range user.Authentications {
+		if auth.Email != "" {
+			email = auth.Email
+			break
+		}
+	}
+
+	res := gin.H{
+		"token": at,
+		"exp":   exp,
+		"email": email,
+	}
+
+	for k, v := range extra {
+		res[k] = v
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// tokenFromCookie handles GET /token: it issues an auth token for the refresh token cookie and rotates the cookie, if it is old enough.
+func (s *Service) tokenFromCookie(c *gin.Context) {
+	rs, rerr := s.refreshCookie(c)
+
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	// rotate the refresh token, if it is old enough
+	j, rerr := s.rotateIfDue(c, rs)
+
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	if j != "" {
+		s.setRefreshCookie(c, j)
+	}
+
+	s.writeAuthToken(c, rs.user, nil)
+}
+
+type refreshTokenBody struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+// tokenFromBody handles POST /token: like GET /token, but for clients that can't use cookies. The refresh token is read from
+// the Authorization header ("Bearer ...") or the refresh_token field of the body (JSON or form encoded). A rotated refresh token
+// is returned in the response instead of a cookie.
+func (s *Service) tokenFromBody(c *gin.Context) {
+	raw, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	if !found {
+		var body refreshTokenBody
+		_ = c.ShouldBind(&body)
+		raw = body.RefreshToken
+	}
+
+	if strings.TrimSpace(raw) == "" {
+		unauthorized("no token").write(c)
+		return
+	}
+
+	rs, rerr := s.checkRefreshToken(c, strings.TrimSpace(raw))
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	// rotate the refresh token, if it is old enough
+	j, rerr := s.rotateIfDue(c, rs)
+	if rerr != nil {
+		rerr.write(c)
+		return
+	}
+
+	var extra gin.H
+	if j != "" {
+		extra = gin.H{
+			"refresh_token": j,
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	s.writeAuthToken(c, rs.user, extra)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.CookieName = "katok"
+	cfg.Db.Type = "sqlite"
+	cfg.Db.DSN = filepath.Join(t.TempDir(), "kingdom-auth.db")
+	cfg.Db.RunMigrations = true
+	cfg.Token.Issuer = "https://auth.example.com"
+	cfg.Token.RefreshTokenTTL = 3600
+	cfg.Token.MinAgeForRefresh = 60
+	cfg.Token.ReuseGrace = 10
+	cfg.Token.AuthTokenTTL = 90
+
+	driver, err := db.NewDriver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := keys.NewRing(newTestKey(t, "ES256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewService(cfg, driver, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// refreshCookieOf returns the refresh token cookie set by a response, or nil.
+func refreshCookieOf(s *Service, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == s.config.CookieName {
+			return cookie
+		}
+	}
+
+	return nil
+}
+
+func TestRefreshCookieRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+
+	start := time.Now()
+	t.Cleanup(func() { clock = time.Now })
+
+	user, err := s.db.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the end of a login
+	token, err := s.createRefreshTokenFor(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	s.setRefreshCookie(c, token)
+
+	cookie := refreshCookieOf(s, w)
+	if cookie == nil || cookie.MaxAge != int(s.config.Token.RefreshTokenTTL) {
+		t.Fatalf("login cookie = %v, want Max-Age %d", cookie, s.config.Token.RefreshTokenTTL)
+	}
+
+	r := gin.New()
+	r.GET("/token", s.tokenFromCookie)
+
+	refresh := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/token", nil)
+		req.AddCookie(cookie)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /token: status %d: %s", w.Code, w.Body.String())
+		}
+
+		return w
+	}
+
+	// too young to be rotated
+	clock = func() time.Time { return start.Add(time.Duration(s.config.Token.MinAgeForRefresh-1) * time.Second) }
+
+	if rotated := refreshCookieOf(s, refresh(cookie)); rotated != nil {
+		t.Fatalf("refresh token was rotated before refresh_token_min_age")
+	}
+
+	// a day later - the cookie of the login is still sent, as it lives as long as the refresh token
+	clock = func() time.Time { return start.Add(time.Duration(s.config.Token.MinAgeForRefresh+1) * time.Second) }
+
+	rotated := refreshCookieOf(s, refresh(cookie))
+	if rotated == nil {
+		t.Fatal("refresh token wasn't rotated after refresh_token_min_age")
+	}
+
+	if rotated.Value == cookie.Value || rotated.MaxAge != int(s.config.Token.RefreshTokenTTL) {
+		t.Errorf("rotated cookie = %v, want a new token with Max-Age %d", rotated, s.config.Token.RefreshTokenTTL)
+	}
+
+	// the old token is still accepted within the reuse grace, but doesn't rotate again
+	if again := refreshCookieOf(s, refresh(cookie)); again != nil {
+		t.Errorf("rotated refresh token was rotated again")
+	}
+
+	refresh(rotated)
+}
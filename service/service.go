@@ -1,10 +1,6 @@
 package service
 
 import (
-	"context"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -16,104 +12,104 @@ import (
 	"github.com/5000K/kingdom-auth/config"
 	"github.com/5000K/kingdom-auth/core"
 	"github.com/5000K/kingdom-auth/db"
+	"github.com/5000K/kingdom-auth/keys"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/oauth2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-var closeWindowPage = []byte("<html><script>window.close();</script><body><h1>Authentication is complete.</h1>You may now close this window/tab.</body></html>")
-
 type Service struct {
 	config *config.Config
 	log    *slog.Logger
 	db     *db.Driver
 
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	keys *keys.Ring
 }
 
-func NewService(config *config.Config, db *db.Driver) (*Service, error) {
-	// Load private key
-	privateKeyData, err := os.ReadFile(config.Token.PrivateKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %w", err)
-	}
+// clock returns the current time for issuing and rotating refresh tokens. Tests move it forward.
+var clock = time.Now
 
-	privateKeyBlock, _ := pem.Decode(privateKeyData)
-	if privateKeyBlock == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing private key")
-	}
+func NewService(config *config.Config, db *db.Driver, ring *keys.Ring) (*Service, error) {
+	return &Service{
+		config: config,
+		db:     db,
+		keys:   ring,
+		log:    slog.With("source", "auth-service"),
+	}, nil
+}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		// Try PKCS8 format as fallback
-		keyInterface, err := x509.ParsePKCS8PrivateKey(privateKeyBlock.Bytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-		var ok bool
-		privateKey, ok = keyInterface.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("not an RSA private key")
-		}
-	}
+// sign signs claims with the active key of the keyring. Every token kingdom-auth hands out is signed through here.
+func (s *Service) sign(claims jwt.Claims) (string, error) {
+	return s.keys.Sign(claims)
+}
 
-	// Load public key
-	publicKeyData, err := os.ReadFile(config.Token.PublicKeyPath)
+func (s *Service) getRedirectUrl(providerName string) string {
+	return fmt.Sprintf("%s/auth/end/%s", s.config.MainService.PublicUrl, providerName)
+}
+
+// createRefreshTokenFor starts a new session (and session family) for the user and returns a refresh token bound to it.
+func (s *Service) createRefreshTokenFor(user *db.User) (string, error) {
+	session, err := s.db.CreateSession(user, s.refreshTokenExpiry())
 	if err != nil {
-		return nil, fmt.Errorf("failed to read public key file: %w", err)
+		return "", err
 	}
 
-	publicKeyBlock, _ := pem.Decode(publicKeyData)
-	if publicKeyBlock == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing public key")
-	}
+	return s.signRefreshToken(session)
+}
 
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+// rotateRefreshToken replaces the session with a new one of the same family and returns a refresh token bound to the new session.
+func (s *Service) rotateRefreshToken(session *db.Session) (string, error) {
+	next, err := s.db.RotateSession(session, s.refreshTokenExpiry())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %w", err)
-	}
-
-	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("not an RSA public key")
+		return "", err
 	}
 
-	return &Service{
-		config:     config,
-		db:         db,
-		privateKey: privateKey,
-		publicKey:  publicKey,
-		log:        slog.With("source", "auth-service"),
-	}, nil
+	return s.signRefreshToken(next)
 }
 
-func (s *Service) getRedirectUrl(providerName string) string {
-	return fmt.Sprintf("%s/auth/end/%s", s.config.MainService.PublicUrl, providerName)
+func (s *Service) refreshTokenExpiry() time.Time {
+	return clock().Add(time.Second * time.Duration(s.config.Token.RefreshTokenTTL))
 }
 
-func (s *Service) createRefreshTokenFor(user *db.User) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
-		"sub":                        fmt.Sprintf("%d", user.ID),
+func (s *Service) signRefreshToken(session *db.Session) (string, error) {
+	return s.sign(jwt.MapClaims{
+		"sub":                        fmt.Sprintf("%d", session.UserID),
 		"iss":                        s.config.Token.Issuer,
-		"exp":                        time.Now().Add(time.Second * time.Duration(s.config.Token.RefreshTokenTTL)).Unix(),
-		"iat":                        time.Now().Unix(),
+		"exp":                        session.ExpiresAt.Unix(),
+		"iat":                        clock().Unix(),
+		"jti":                        session.JTI,
+		core.TokenUseClaim:           core.TokenUseRefresh,
 		core.KingdomAuthVersionClaim: core.KingdomAuthVersion,
 	})
+}
 
-	return t.SignedString(s.privateKey)
+// revokeReusedFamily handles a refresh token that was presented again after it had been rotated. This means the token
+// was copied at some point, so every session of that login is revoked - the thief's and the user's.
+func (s *Service) revokeReusedFamily(session *db.Session, c *gin.Context) {
+	n, err := s.db.RevokeFamily(session.FamilyID)
+	if err != nil {
+		s.log.Error("failed to revoke session family after refresh token reuse", "user", session.UserID, "family", session.FamilyID, "error", err)
+		return
+	}
+
+	s.log.Warn("refresh token reuse detected - revoked session family", "user", session.UserID, "family", session.FamilyID, "revoked", n, "ip", c.ClientIP())
 }
 
 func (s *Service) readRefreshToken(token string) (jwt.MapClaims, error) {
+	return s.parseToken(token)
+}
+
+// parseToken verifies the signature and expiry of a token and returns its claims.
+func (s *Service) parseToken(token string) (jwt.MapClaims, error) {
 	contents := jwt.MapClaims{}
-	tkn, err := jwt.ParseWithClaims(token, &contents, func(token *jwt.Token) (interface{}, error) {
-		return s.publicKey, nil
-	})
+	tkn, err := jwt.ParseWithClaims(token, &contents, s.keys.Keyfunc)
 
 	if err != nil {
-		if errors.Is(err, jwt.ErrSignatureInvalid) {
+		// a retired or unknown key can't vouch for the token anymore
+		if errors.Is(err, jwt.ErrSignatureInvalid) || errors.Is(err, keys.ErrUnknownKey) {
 			return nil, core.ErrInvalidSignature
 		}
 
@@ -121,6 +117,10 @@ func (s *Service) readRefreshToken(token string) (jwt.MapClaims, error) {
 			return nil, core.ErrTokenExpired
 		}
 
+		if errors.Is(err, keys.ErrUnexpectedMethod) || errors.Is(err, jwt.ErrTokenMalformed) {
+			return nil, core.ErrTokenInvalid
+		}
+
 		s.log.Debug("failed to parse token", "err", err)
 		return nil, core.ErrFailedToParseToken
 	}
@@ -133,6 +133,20 @@ func (s *Service) readRefreshToken(token string) (jwt.MapClaims, error) {
 }
 
 func (s *Service) createAuthTokenFor(user *db.User) (string, int64, error) {
+	exp := time.Now().Add(time.Second * time.Duration(s.config.Token.AuthTokenTTL)).Unix()
+
+	claims, err := s.authTokenClaims(user, exp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tk, err := s.sign(claims)
+
+	return tk, exp, err
+}
+
+// authTokenClaims returns the claims of an auth token for the user.
+func (s *Service) authTokenClaims(user *db.User, exp int64) (jwt.MapClaims, error) {
 	aud := s.config.Token.DefaultAudience
 
 	pud, err := user.GetPublicUserdata()
@@ -144,47 +158,55 @@ func (s *Service) createAuthTokenFor(user *db.User) (string, int64, error) {
 		}
 	}
 
-	exp := time.Now().Add(time.Second * time.Duration(s.config.Token.AuthTokenTTL)).Unix()
+	roles, permissions, err := s.db.RolesAndPermissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+	return jwt.MapClaims{
 		"sub":                        fmt.Sprintf("%d", user.ID),
 		"aud":                        aud,
 		"iss":                        s.config.Token.Issuer,
 		"exp":                        exp,
 		"iat":                        time.Now().Unix(),
 		"public-data":                pud,
+		core.RolesClaim:              roles,
+		core.PermissionsClaim:        permissions,
+		core.TokenUseClaim:           core.TokenUseAuth,
 		core.KingdomAuthVersionClaim: core.KingdomAuthVersion,
-	})
-
-	tk, err := t.SignedString(s.privateKey)
-
-	return tk, exp, err
+	}, nil
 }
 
 func (s *Service) readAuthToken(token string) (jwt.MapClaims, error) {
-	contents := jwt.MapClaims{}
-	tkn, err := jwt.ParseWithClaims(token, &contents, func(token *jwt.Token) (interface{}, error) {
-		return s.publicKey, nil
-	})
+	return s.parseToken(token)
+}
 
-	if err != nil {
-		if errors.Is(err, jwt.ErrSignatureInvalid) {
-			return nil, core.ErrInvalidSignature
+// cleanupSessions periodically removes expired sessions, device codes and authorization codes, they can't be used anymore anyway.
+func (s *Service) cleanupSessions() {
+	for {
+		n, err := s.db.DeleteExpiredSessions(time.Now())
+		if err != nil {
+			s.log.Warn("failed to delete expired sessions", "error", err)
+		} else if n > 0 {
+			s.log.Debug("deleted expired sessions", "count", n)
 		}
 
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, core.ErrTokenExpired
+		n, err = s.db.DeleteExpiredDeviceCodes(time.Now())
+		if err != nil {
+			s.log.Warn("failed to delete expired device codes", "error", err)
+		} else if n > 0 {
+			s.log.Debug("deleted expired device codes", "count", n)
 		}
 
-		s.log.Debug("failed to parse token", "err", err)
-		return nil, core.ErrFailedToParseToken
-	}
+		n, err = s.db.DeleteExpiredAuthorizationCodes(time.Now())
+		if err != nil {
+			s.log.Warn("failed to delete expired authorization codes", "error", err)
+		} else if n > 0 {
+			s.log.Debug("deleted expired authorization codes", "count", n)
+		}
 
-	if !tkn.Valid {
-		return nil, core.ErrTokenInvalid
+		time.Sleep(time.Hour)
 	}
-
-	return contents, nil
 }
 
 func (s *Service) Run() {
@@ -206,6 +228,16 @@ func (s *Service) Run() {
 		return
 	}
 
+	// the refresh cookie lives as long as the refresh token - it has to be rotated before that
+	if s.config.Token.MinAgeForRefresh >= s.config.Token.RefreshTokenTTL {
+		s.log.Error("refresh_token_min_age must be below refresh_token_ttl - refresh tokens would never be rotated - can't start",
+			"refresh_token_min_age", s.config.Token.MinAgeForRefresh, "refresh_token_ttl", s.config.Token.RefreshTokenTTL)
+		os.Exit(1)
+		return
+	}
+
+	go s.cleanupSessions()
+
 	r := gin.New()
 
 	r.Use(cors.New(cors.Config{
@@ -219,6 +251,9 @@ func (s *Service) Run() {
 
 	r.Use(logger.SetLogger())
 
+	r.GET("/.well-known/jwks.json", s.jwks)
+	r.GET("/.well-known/openid-configuration", s.openidConfiguration)
+
 	r.GET("/providers", func(c *gin.Context) {
 		list := make([]string, 0)
 
@@ -236,9 +271,7 @@ func (s *Service) Run() {
 
 		for _, provider := range providers {
 			if provider.Name == prov {
-				// redirect
-				url := provider.config.AuthCodeURL("state", oauth2.AccessTypeOffline)
-				c.Redirect(http.StatusFound, url)
+				s.beginLogin(c, provider)
 				return
 			}
 		}
@@ -248,91 +281,12 @@ func (s *Service) Run() {
 		})
 	})
 
-	r.GET("/auth/end/:provider", func(c *gin.Context) {
+	r.GET("/auth/link/:provider", func(c *gin.Context) {
 		prov := c.Param("provider")
 
 		for _, provider := range providers {
 			if provider.Name == prov {
-				code := c.Query("code")
-				token, err := provider.config.Exchange(context.Background(), code)
-
-				if err != nil {
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					s.log.Info("exchange error", "error", err)
-					return
-				}
-
-				userInfo, err := provider.OICDProvider.UserInfo(context.Background(), provider.config.TokenSource(context.Background(), token))
-				if err != nil {
-					return
-				}
-
-				if err != nil {
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					s.log.Info("verifier error", "error", err)
-					return
-				}
-
-				// try get auth
-				auth, err := s.db.TryGetAuthentication(provider.Name, userInfo.Subject)
-
-				if err != nil {
-					// new user!
-					usr, err := s.db.CreateUser()
-					if err != nil {
-						c.Writer.WriteHeader(http.StatusInternalServerError)
-						s.log.Info("create user error", "error", err)
-						return
-					}
-
-					auth, err = s.db.CreateAuthenticationFor(usr)
-					if err != nil {
-						c.Writer.WriteHeader(http.StatusInternalServerError)
-						s.log.Info("create auth error", "error", err)
-						return
-					}
-
-					auth.Provider = provider.Name
-					auth.Subject = userInfo.Subject
-					auth.Email = userInfo.Email
-					err = s.db.UpdateAuthentication(auth)
-					if err != nil {
-						c.Writer.WriteHeader(http.StatusInternalServerError)
-						s.log.Info("update auth error", "error", err)
-						return
-					}
-				}
-
-				user, err := s.db.GetUserFor(auth)
-
-				if err != nil {
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					s.log.Info("get user error", "error", err)
-					return
-				}
-
-				user.LastLogin = time.Now()
-				_ = s.db.UpdateUser(user)
-
-				j, err := s.createRefreshTokenFor(user)
-				if err != nil {
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					s.log.Info("create jwt error", "error", err)
-					return
-				}
-
-				c.SetCookie(s.config.CookieName, j, 3600*24, "/", s.config.CookieDomain, true, true)
-
-				// write close window page
-
-				c.Writer.WriteHeader(http.StatusOK)
-				c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
-				c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-				c.Writer.Header().Set("Pragma", "no-cache")
-				c.Writer.Header().Set("Expires", "0")
-
-				// TODO: allow to use a custom post-auth redirect via config.
-				_, _ = c.Writer.Write(closeWindowPage)
+				s.beginLink(c, provider)
 				return
 			}
 		}
@@ -342,139 +296,98 @@ func (s *Service) Run() {
 		})
 	})
 
-	r.GET("/token", func(c *gin.Context) {
-		cookieString, err := c.Cookie(s.config.CookieName)
+	r.DELETE("/auth/link/:provider", s.unlink)
+	r.GET("/auth/identities", s.identities)
 
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "no token",
-			})
-			return
-		}
-
-		tk, err := s.readRefreshToken(cookieString)
+	r.GET("/auth/end/:provider", func(c *gin.Context) {
+		prov := c.Param("provider")
 
-		if err != nil {
-			if errors.Is(err, core.ErrTokenExpired) {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error": "token expired",
-				})
-				return
-			} else if errors.Is(err, core.ErrTokenInvalid) {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error": "token invalid",
-				})
-				return
-			} else if errors.Is(err, core.ErrInvalidSignature) {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error": "token signature invalid",
-				})
+		for _, provider := range providers {
+			if provider.Name == prov {
+				s.finishLogin(c, provider)
 				return
 			}
-			c.Writer.WriteHeader(http.StatusInternalServerError)
-			return
 		}
 
-		version := tk[core.KingdomAuthVersionClaim]
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "provider not found",
+		})
+	})
 
-		if version != core.KingdomAuthVersion {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":    "version mismatch: token is from another format (older or newer)",
-				"expected": core.KingdomAuthVersion,
-				"actual":   version,
-			})
-			return
-		}
+	if s.config.Device.Enabled {
+		r.POST("/device/code", s.deviceCode)
+		r.GET("/device", s.devicePage)
+		r.POST("/device/token", s.deviceToken)
+	}
 
-		iss, err := tk.GetIssuer()
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusInternalServerError)
-			s.log.Info("get issuer error", "error", err)
-			return
-		}
+	// always served, the discovery document has to name an authorization endpoint - without clients, it rejects every request
+	r.GET("/authorize", s.authorize)
 
-		if iss != s.config.Token.Issuer {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":    "issuer mismatch",
-				"expected": s.config.Token.Issuer,
-				"actual":   iss,
-			})
-			return
+	for _, client := range s.config.OIDC.Clients {
+		if _, err := bcrypt.Cost([]byte(client.SecretHash)); client.SecretHash != "" && err != nil {
+			s.log.Warn("secret_hash of client is no bcrypt hash - it can't redeem codes", "client", client.ID, "error", err)
 		}
+	}
 
-		uidS, err := tk.GetSubject()
-
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusInternalServerError)
-			s.log.Info("get subject error", "error", err)
-			return
+	if len(s.config.OIDC.Clients) > 0 {
+		if s.config.Token.Issuer != s.config.MainService.PublicUrl {
+			s.log.Warn("token issuer differs from the public url - OpenID Connect clients may reject the tokens", "issuer", s.config.Token.Issuer, "public_url", s.config.MainService.PublicUrl)
 		}
 
-		uid, err := strconv.ParseUint(uidS, 10, 32)
+		r.GET("/userinfo", s.userinfo)
+		r.POST("/userinfo", s.userinfo)
+	}
 
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusInternalServerError)
+	for _, client := range s.config.ServiceClients.Clients {
+		// sub of client tokens is the client id - numeric ids would be indistinguishable from user ids
+		if _, err := strconv.ParseUint(client.ID, 10, 64); err == nil {
+			s.log.Error("service client id must not be numeric - can't start", "client", client.ID)
+			os.Exit(1)
 			return
 		}
 
-		// find user
-		user, err := s.db.GetUser(uint32(uid))
-
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusNotFound)
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "token valid, but user not found",
-			})
-			return
+		if _, err := bcrypt.Cost([]byte(client.SecretHash)); err != nil {
+			s.log.Warn("secret_hash of service client is no bcrypt hash - it can't get tokens", "client", client.ID, "error", err)
 		}
+	}
 
-		// check for expiry, send a new refresh token, if token is old enough
-		expiry, err := tk.GetExpirationTime()
+	if len(s.config.TokenExchange.Audiences) > 0 {
+		r.POST("/token/exchange", s.exchangeToken)
+	}
 
-		if err == nil {
-			issueDate, err := tk.GetIssuedAt()
-			if err == nil {
-				timeSinceIssue := uint(issueDate.Time.Sub(expiry.Time).Seconds())
+	if len(s.config.OIDC.Clients) > 0 || len(s.config.ServiceClients.Clients) > 0 {
+		r.POST("/oauth/token", s.oauthToken)
+	}
 
-				if timeSinceIssue > s.config.Token.MinAgeForRefresh {
-					j, err := s.createRefreshTokenFor(user)
-					if err != nil {
-						c.Writer.WriteHeader(http.StatusInternalServerError)
-						s.log.Info("create jwt error", "error", err)
-						return
-					}
+	r.GET("/token", s.tokenFromCookie)
 
-					c.SetCookie(s.config.CookieName, j, 3600*24, "/", s.config.CookieDomain, true, true)
-				}
-			}
-		}
+	if !s.config.Token.CookieOnly {
+		r.POST("/token", s.tokenFromBody)
+	}
 
-		at, exp, err := s.createAuthTokenFor(user)
+	r.GET("/auth/logout", func(c *gin.Context) {
+		cookieString, err := c.Cookie(s.config.CookieName)
 
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusInternalServerError)
-			s.log.Info("create jwt error", "error", err)
-			return
-		}
+		if err == nil {
+			tk, err := s.readRefreshToken(cookieString)
+
+			if err == nil {
+				jti, _ := tk["jti"].(string)
 
-		// search email from authentications
+				// logging out ends the whole login, including refresh tokens that were rotated away already
+				session, err := s.db.GetSession(jti)
+				if err == nil {
+					_, err = s.db.RevokeFamily(session.FamilyID)
+				}
 
-		email := ""
-		for _, auth := range user.Authentications {
-			if auth.Email != "" {
-				email = auth.Email
-				break
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					c.Writer.WriteHeader(http.StatusInternalServerError)
+					s.log.Info("revoke session error", "error", err)
+					return
+				}
 			}
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"token": at,
-			"exp":   exp,
-			"email": email,
-		})
-	})
-
-	r.GET("/auth/logout", func(c *gin.Context) {
 		c.SetCookie(s.config.CookieName, "", -1, "/", s.config.CookieDomain, true, true)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "logged out",
@@ -533,6 +446,23 @@ func (s *Service) Run() {
 			return
 		}
 
+		if iss, _ := tk.GetIssuer(); iss != s.config.Token.Issuer {
+			c.JSON(http.StatusOK, gin.H{
+				"valid": false,
+				"error": "issuer mismatch",
+			})
+			return
+		}
+
+		// refresh tokens and tokens of OpenID Connect clients must not be used to call services
+		if !core.IsAccessToken(tk) {
+			c.JSON(http.StatusOK, gin.H{
+				"valid": false,
+				"error": "token is no auth or service token",
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"valid":  true,
 			"claims": tk,
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestKey(t *testing.T, alg string) *keys.Key {
+	t.Helper()
+
+	private, err := keys.Generate(alg, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := keys.NewKey(private, nil, alg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Method().Alg() != alg {
+		t.Fatalf("key signs with %s, want %s", k.Method().Alg(), alg)
+	}
+
+	return k
+}
+
+func TestParseTokenKeyringErrors(t *testing.T) {
+	old := newTestKey(t, "RS256")
+	current := newTestKey(t, "ES256")
+	other := newTestKey(t, "EdDSA")
+
+	ring, err := keys.NewRing(old, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{config: &config.Config{}, keys: ring}
+
+	err = ring.Promote(old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Minute).Unix()}
+
+	signedWithOld, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreignRing, err := keys.NewRing(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedWithForeign, err := foreignRing.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a token signed with a known kid, but another algorithm
+	wrongMethod := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	wrongMethod.Header["kid"] = current.ID
+	signedWrongMethod, err := wrongMethod.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.parseToken(signedWithOld); err != nil {
+		t.Fatalf("token of the active key rejected: %v", err)
+	}
+
+	// the documented rotation: promote the new key, retire the old one
+	if err = ring.Promote(current.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ring.Retire(old.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"retired key", signedWithOld, core.ErrInvalidSignature},
+		{"unknown key", signedWithForeign, core.ErrInvalidSignature},
+		{"unexpected method", signedWrongMethod, core.ErrTokenInvalid},
+		{"malformed", "not-a-token", core.ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		_, err := s.parseToken(tt.token)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
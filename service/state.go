@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// stateTokenPurpose marks a signed token as login state, so it can't be mixed up with refresh or auth tokens.
+const stateTokenPurpose = "oauth-state"
+
+var (
+	errStateMissing  = errors.New("login state cookie missing")
+	errStateInvalid  = errors.New("login state invalid")
+	errStateMismatch = errors.New("login state mismatch")
+)
+
+// authState is carried through the OAuth flow in a short-lived, signed cookie.
+// It binds the flow to the browser that started it: the callback is only accepted if the state returned by the provider matches it.
+type authState struct {
+	jwt.RegisteredClaims
+
+	Purpose  string `json:"purpose"`
+	State    string `json:"state"`
+	Provider string `json:"provider"`
+
+	// PKCE code verifier, empty if the provider doesn't use PKCE
+	Verifier string `json:"verifier,omitempty"`
+
+	// expected nonce of the ID token, OIDC providers only
+	Nonce string `json:"nonce,omitempty"`
+
+	// where to send the browser after the login, both already checked against the redirect allow-list
+	RedirectURI string `json:"redirect_uri,omitempty"`
+	ErrorURI    string `json:"error_uri,omitempty"`
+
+	// set if the flow links another provider to an existing user instead of logging in
+	LinkUserID uint `json:"link_user,omitempty"`
+
+	// set if the flow approves a device (see /device) instead of logging in the browser
+	DeviceUserCode string `json:"device_user_code,omitempty"`
+}
+
+// randomString returns a url-safe string encoding n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Service) stateCookieName() string {
+	return s.config.CookieName + "_state"
+}
+
+func (s *Service) setStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     s.stateCookieName(),
+		Value:    value,
+		Path:     "/auth",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		// the provider redirects back with a top-level navigation, which Lax still allows
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// beginAuthState completes the given login state for the provider and stores it in the state cookie.
+// The caller sets what the flow is for (redirect targets, user to link to, device to approve).
+func (s *Service) beginAuthState(c *gin.Context, provider *Provider, st *authState) error {
+	state, err := randomString(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ttl := time.Second * time.Duration(s.config.Auth.StateTTL)
+
+	st.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    s.config.Token.Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	st.Purpose = stateTokenPurpose
+	st.State = state
+	st.Provider = provider.Name
+
+	if provider.usePKCE {
+		st.Verifier = oauth2.GenerateVerifier()
+	}
+
+	if provider.kind == providerTypeOIDC {
+		st.Nonce, err = randomString(16)
+		if err != nil {
+			return err
+		}
+	}
+
+	signed, err := s.sign(st)
+	if err != nil {
+		return err
+	}
+
+	s.setStateCookie(c, signed, int(s.config.Auth.StateTTL))
+
+	return nil
+}
+
+// consumeAuthState reads and clears the state cookie and checks it against the state returned by the provider.
+// On errStateMismatch, the (authentic) state from the cookie is returned alongside the error, so the failure can still be
+// reported to the redirect targets of the login.
+func (s *Service) consumeAuthState(c *gin.Context, provider string) (*authState, error) {
+	cookie, err := c.Cookie(s.stateCookieName())
+	if err != nil || cookie == "" {
+		return nil, errStateMissing
+	}
+
+	// a state can only be used once
+	s.setStateCookie(c, "", -1)
+
+	st := &authState{}
+	_, err = jwt.ParseWithClaims(cookie, st, s.keys.Keyfunc, jwt.WithIssuer(s.config.Token.Issuer))
+
+	if err != nil {
+		s.log.Debug("failed to parse state", "err", err)
+		return nil, errStateInvalid
+	}
+
+	if st.Purpose != stateTokenPurpose || st.Provider != provider {
+		return nil, errStateInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(st.State), []byte(c.Query("state"))) != 1 {
+		return st, errStateMismatch
+	}
+
+	return st, nil
+}
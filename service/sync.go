@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+)
+
+// claimSync copies a single claim into the userdata.
+type claimSync struct {
+	claim   claimPath
+	private bool
+	key     string
+	always  bool
+}
+
+// newClaimSyncs parses the sync_claims of a provider.
+func newClaimSyncs(cfgs []config.SyncClaimConfig) ([]claimSync, error) {
+	syncs := make([]claimSync, 0, len(cfgs))
+
+	for _, cfg := range cfgs {
+		path, err := parseClaimPath(cfg.Claim)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(path) == 0 {
+			return nil, fmt.Errorf("sync_claims: claim is required")
+		}
+
+		sync := claimSync{
+			claim: path,
+			key:   cfg.Key,
+		}
+
+		if sync.key == "" {
+			// the last key of the selector
+			for _, elem := range path {
+				if key, ok := elem.(string); ok {
+					sync.key = key
+				}
+			}
+		}
+
+		if sync.key == "" {
+			return nil, fmt.Errorf("sync_claims: key is required for claim %q", cfg.Claim)
+		}
+
+		switch cfg.Target {
+		case "", "public":
+		case "private":
+			sync.private = true
+		default:
+			return nil, fmt.Errorf("sync_claims: invalid target %q (supported: public, private)", cfg.Target)
+		}
+
+		switch cfg.Mode {
+		case "", "always":
+			sync.always = true
+		case "first_login":
+		default:
+			return nil, fmt.Errorf("sync_claims: invalid mode %q (supported: always, first_login)", cfg.Mode)
+		}
+
+		syncs = append(syncs, sync)
+	}
+
+	return syncs, nil
+}
+
+// syncClaims copies the configured claims of the identity into the user's userdata. Claims the provider didn't send
+// leave the userdata untouched. Has to run before LastLogin of the user is updated, first_login claims depend on it.
+func (p *Provider) syncClaims(user *db.User, id *identity) error {
+	if len(p.sync) == 0 {
+		return nil
+	}
+
+	pub, err := user.GetPublicUserdata()
+	if err != nil {
+		return err
+	}
+
+	priv, err := user.GetPrivateUserdata()
+	if err != nil {
+		return err
+	}
+
+	firstLogin := !user.HasLoggedIn()
+
+	for _, sync := range p.sync {
+		if !sync.always && !firstLogin {
+			continue
+		}
+
+		value, ok := sync.claim.lookup(id.Claims)
+		if !ok {
+			continue
+		}
+
+		target := pub
+		if sync.private {
+			target = priv
+		}
+
+		target[sync.key] = value
+	}
+
+	err = user.SetPublicUserdata(pub)
+	if err != nil {
+		return err
+	}
+
+	return user.SetPrivateUserdata(priv)
+}
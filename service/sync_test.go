@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/db"
+)
+
+func TestSyncClaimsModes(t *testing.T) {
+	syncs, err := newClaimSyncs([]config.SyncClaimConfig{
+		{Claim: "name", Key: "display_name"},
+		{Claim: "picture", Mode: "first_login"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{sync: syncs}
+	id := &identity{Claims: map[string]any{"name": "Ada", "picture": "https://example.com/ada.png"}}
+
+	user := &db.User{PublicData: "{}", PrivateData: "{}", LastLogin: time.UnixMilli(0)}
+
+	if err := p.syncClaims(user, id); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := user.GetPublicUserdata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pub["display_name"] != "Ada" || pub["picture"] != "https://example.com/ada.png" {
+		t.Fatalf("first login: userdata = %v, want display_name and picture", pub)
+	}
+
+	// the picture is removed through the system service, the name changes at the provider
+	delete(pub, "picture")
+	if err := user.SetPublicUserdata(pub); err != nil {
+		t.Fatal(err)
+	}
+
+	user.LastLogin = time.Now()
+	id.Claims["name"] = "Ada L."
+
+	if err := p.syncClaims(user, id); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err = user.GetPublicUserdata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pub["display_name"] != "Ada L." {
+		t.Errorf("later login: display_name = %v, want it overwritten", pub["display_name"])
+	}
+
+	if _, ok := pub["picture"]; ok {
+		t.Errorf("later login: picture = %v, want it to stay removed", pub["picture"])
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/gin-gonic/gin"
+)
+
+// jwks serves the public key(s) used to sign tokens, so they can be verified with any standard JWT library.
+func (s *Service) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+
+	c.JSON(http.StatusOK, s.keys.JWKS())
+}
+
+// openidConfiguration serves the OpenID Connect discovery document (https://openid.net/specs/openid-connect-discovery-1_0.html).
+func (s *Service) openidConfiguration(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+
+	algs := make([]string, 0)
+	for _, k := range s.keys.JWKS().Keys {
+		if !slices.Contains(algs, k.Alg) {
+			algs = append(algs, k.Alg)
+		}
+	}
+
+	doc := gin.H{
+		"issuer":                                s.config.Token.Issuer,
+		"jwks_uri":                              s.config.MainService.PublicUrl + "/.well-known/jwks.json",
+		"authorization_endpoint":                s.authorizeURL(),
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": algs,
+		"subject_types_supported":               []string{"public"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "public-data"},
+	}
+
+	// kingdom-auth only acts as OpenID Connect provider if there are clients
+	if len(s.config.OIDC.Clients) > 0 {
+		doc["userinfo_endpoint"] = s.config.MainService.PublicUrl + "/userinfo"
+		doc["code_challenge_methods_supported"] = []string{"S256"}
+		doc["scopes_supported"] = supportedScopes
+		doc["authorization_response_iss_parameter_supported"] = true
+		doc["claims_supported"] = []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "public-data",
+			"name", "preferred_username", "picture", "locale", "email", "email_verified", core.RolesClaim, core.PermissionsClaim}
+	}
+
+	grants := make([]string, 0)
+	authMethods := []string{"client_secret_basic", "client_secret_post"}
+
+	if len(s.config.OIDC.Clients) > 0 {
+		grants = append(grants, "authorization_code")
+		authMethods = append(authMethods, "none")
+	}
+
+	if len(s.config.ServiceClients.Clients) > 0 {
+		grants = append(grants, "client_credentials")
+	}
+
+	if len(grants) > 0 {
+		doc["token_endpoint"] = s.config.MainService.PublicUrl + "/oauth/token"
+		doc["grant_types_supported"] = grants
+		doc["token_endpoint_auth_methods_supported"] = authMethods
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
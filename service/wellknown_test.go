@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/5000K/kingdom-auth/config"
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenIDConfigurationRequiredMetadata(t *testing.T) {
+	ring, err := keys.NewRing(newTestKey(t, "ES256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the REQUIRED metadata of https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+	required := []string{"issuer", "authorization_endpoint", "jwks_uri", "response_types_supported", "subject_types_supported",
+		"id_token_signing_alg_values_supported"}
+
+	for _, tt := range []struct {
+		name    string
+		clients []config.ClientConfig
+	}{
+		{name: "without clients"},
+		{name: "with clients", clients: []config.ClientConfig{{ID: "wiki"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Token.Issuer = "https://auth.example.com"
+			cfg.MainService.PublicUrl = "https://auth.example.com"
+			cfg.OIDC.Clients = tt.clients
+
+			s := &Service{config: cfg, keys: ring}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil)
+
+			s.openidConfiguration(c)
+
+			var doc map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range required {
+				if _, ok := doc[name]; !ok {
+					t.Errorf("%s is missing", name)
+				}
+			}
+		})
+	}
+}
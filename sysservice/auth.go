@@ -0,0 +1,62 @@
+package sysservice
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// systemTokenKey is the gin context key holding the name of the system token that authenticated the request.
+const systemTokenKey = "system-token"
+
+// findSystemToken returns the name of the configured system token matching the given secret.
+// Every configured token is compared in constant time, so the response time does not leak which token (if any) matched.
+func (s *Service) findSystemToken(secret string) (string, bool) {
+	name := ""
+	found := 0
+
+	for _, t := range s.config.SystemService.Tokens {
+		if t.Token == "" {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(secret)) == 1 {
+			name = t.Name
+			found = 1
+		}
+	}
+
+	return name, found == 1
+}
+
+// requireSystemToken rejects every request that does not carry a configured system token as bearer token.
+func (s *Service) requireSystemToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || secret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "no token",
+			})
+			return
+		}
+
+		name, ok := s.findSystemToken(secret)
+		if !ok {
+			s.log.Warn("rejected system request with unknown token", "method", c.Request.Method, "path", c.Request.URL.Path, "ip", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "token invalid",
+			})
+			return
+		}
+
+		c.Set(systemTokenKey, name)
+
+		c.Next()
+
+		s.log.Info("system request", "token", name, "method", c.Request.Method, "path", c.Request.URL.Path, "status", c.Writer.Status())
+	}
+}
@@ -0,0 +1,81 @@
+package sysservice
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Service) listKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"keys": s.keys.Status(),
+	})
+}
+
+// writeKeyError writes the response for a failed keyring operation.
+func (s *Service) writeKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, keys.ErrUnknownKey):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "key not found",
+		})
+	case errors.Is(err, keys.ErrKeyCannotSign), errors.Is(err, keys.ErrKeyRetired), errors.Is(err, keys.ErrKeyActive):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("keyring error", "error", err)
+	}
+}
+
+// promoteKey makes a key the active key: every token from now on is signed with it.
+// The change is persisted, but other running instances of kingdom-auth only pick it up after a restart.
+func (s *Service) promoteKey(c *gin.Context) {
+	kid := c.Param("kid")
+
+	err := s.keys.Promote(kid)
+	if err != nil {
+		s.writeKeyError(c, err)
+		return
+	}
+
+	err = s.db.SetActiveKey(kid)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Error("failed to persist active key - it is only active until the next restart", "kid", kid, "error", err)
+		return
+	}
+
+	s.log.Info("promoted key", "kid", kid, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": s.keys.Status(),
+	})
+}
+
+// retireKey stops accepting tokens signed with a key. The active key can't be retired.
+func (s *Service) retireKey(c *gin.Context) {
+	kid := c.Param("kid")
+
+	err := s.keys.Retire(kid)
+	if err != nil {
+		s.writeKeyError(c, err)
+		return
+	}
+
+	err = s.db.SetKeyRetired(kid)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Error("failed to persist retired key - it is only retired until the next restart", "kid", kid, "error", err)
+		return
+	}
+
+	s.log.Info("retired key", "kid", kid, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": s.keys.Status(),
+	})
+}
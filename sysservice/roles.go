@@ -0,0 +1,186 @@
+package sysservice
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type roleBody struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (s *Service) listRoles(c *gin.Context) {
+	roles, err := s.db.ListRoles()
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("list roles error", "error", err)
+		return
+	}
+
+	list := make([]core.Role, 0, len(roles))
+	for _, role := range roles {
+		list = append(list, role.ToCore())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": list,
+	})
+}
+
+func (s *Service) getRole(c *gin.Context) {
+	role, err := s.db.GetRole(c.Param("role"))
+	if err != nil {
+		s.writeRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, role.ToCore())
+}
+
+// putRole creates a role or replaces its description and permissions.
+func (s *Service) putRole(c *gin.Context) {
+	name := c.Param("role")
+
+	var body roleBody
+
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "body must be a json object",
+		})
+		return
+	}
+
+	for _, p := range body.Permissions {
+		if strings.TrimSpace(p) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "permissions must not be empty",
+			})
+			return
+		}
+	}
+
+	role, err := s.db.SaveRole(name, body.Description, body.Permissions)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("save role error", "error", err)
+		return
+	}
+
+	s.log.Info("saved role", "role", name, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, role.ToCore())
+}
+
+func (s *Service) deleteRole(c *gin.Context) {
+	name := c.Param("role")
+
+	err := s.db.DeleteRole(name)
+	if err != nil {
+		s.writeRoleError(c, err)
+		return
+	}
+
+	s.log.Info("deleted role", "role", name, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": name,
+	})
+}
+
+func (s *Service) listUserRoles(c *gin.Context) {
+	user, ok := s.loadUser(c)
+	if !ok {
+		return
+	}
+
+	s.writeUserRoles(c, user)
+}
+
+// assignRole gives a user a role. Roles and permissions show up in the user's next auth token.
+func (s *Service) assignRole(c *gin.Context) {
+	user, ok := s.loadUser(c)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+
+	err := s.db.AssignRole(user.ID, role, db.RoleSourceManual)
+	if err != nil {
+		s.writeRoleError(c, err)
+		return
+	}
+
+	s.log.Info("assigned role", "user", user.ID, "role", role, "token", c.GetString(systemTokenKey))
+
+	s.writeUserRoles(c, user)
+}
+
+func (s *Service) removeRole(c *gin.Context) {
+	user, ok := s.loadUser(c)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+
+	err := s.db.RemoveRole(user.ID, role)
+	if err != nil {
+		s.writeRoleError(c, err)
+		return
+	}
+
+	s.log.Info("removed role", "user", user.ID, "role", role, "token", c.GetString(systemTokenKey))
+
+	s.writeUserRoles(c, user)
+}
+
+func (s *Service) writeUserRoles(c *gin.Context, user *db.User) {
+	assignments, err := s.db.GetRolesFor(user.ID)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("get roles error", "error", err)
+		return
+	}
+
+	list := make([]core.UserRole, 0, len(assignments))
+	for _, a := range assignments {
+		list = append(list, core.UserRole{
+			Role:   a.Role.Name,
+			Source: a.Source,
+		})
+	}
+
+	_, permissions, err := s.db.RolesAndPermissions(user.ID)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("get roles error", "error", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       list,
+		"permissions": permissions,
+	})
+}
+
+// writeRoleError writes the response for a failed role operation.
+func (s *Service) writeRoleError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "role not found",
+		})
+		return
+	}
+
+	c.Writer.WriteHeader(http.StatusInternalServerError)
+	s.log.Info("role error", "error", err)
+}
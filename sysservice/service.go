@@ -1,15 +1,15 @@
 package sysservice
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"log/slog"
-	"os"
+	"net/http"
 
 	"github.com/5000K/kingdom-auth/config"
 	"github.com/5000K/kingdom-auth/db"
+	"github.com/5000K/kingdom-auth/keys"
+	"github.com/gin-contrib/logger"
+	"github.com/gin-gonic/gin"
 )
 
 type Service struct {
@@ -17,62 +17,66 @@ type Service struct {
 	log    *slog.Logger
 	db     *db.Driver
 
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	keys *keys.Ring
 }
 
-func NewService(config *config.Config, db *db.Driver) (*Service, error) {
-	// Load private key
-	privateKeyData, err := os.ReadFile(config.Token.PrivateKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %w", err)
-	}
+func NewService(config *config.Config, db *db.Driver, ring *keys.Ring) (*Service, error) {
+	return &Service{
+		config: config,
+		db:     db,
+		keys:   ring,
+		log:    slog.With("source", "system-service"),
+	}, nil
+}
 
-	privateKeyBlock, _ := pem.Decode(privateKeyData)
-	if privateKeyBlock == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing private key")
+func (s *Service) Run() {
+	if len(s.config.SystemService.Tokens) == 0 {
+		s.log.Warn("no system tokens configured - every request to the system service will be rejected")
 	}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		// Try PKCS8 format as fallback
-		keyInterface, err := x509.ParsePKCS8PrivateKey(privateKeyBlock.Bytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-		var ok bool
-		privateKey, ok = keyInterface.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("not an RSA private key")
-		}
-	}
+	r := gin.New()
 
-	// Load public key
-	publicKeyData, err := os.ReadFile(config.Token.PublicKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read public key file: %w", err)
-	}
+	r.Use(logger.SetLogger())
+	r.Use(gin.Recovery())
+	r.Use(s.requireSystemToken())
 
-	publicKeyBlock, _ := pem.Decode(publicKeyData)
-	if publicKeyBlock == nil {
-		return nil, fmt.Errorf("failed to decode PEM block containing public key")
-	}
+	r.GET("/whoami", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"name": c.GetString(systemTokenKey),
+		})
+	})
 
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %w", err)
-	}
+	r.GET("/users", s.listUsers)
+	r.GET("/users/:id", s.getUser)
+	r.DELETE("/users/:id", s.deleteUser)
 
-	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("not an RSA public key")
-	}
+	r.GET("/users/:id/public-data", s.getUserdata(publicUserdata))
+	r.PUT("/users/:id/public-data", s.writeUserdata(publicUserdata, false))
+	r.PATCH("/users/:id/public-data", s.writeUserdata(publicUserdata, true))
 
-	return &Service{
-		config:     config,
-		db:         db,
-		privateKey: privateKey,
-		publicKey:  publicKey,
-		log:        slog.With("source", "auth-service"),
-	}, nil
+	r.GET("/users/:id/private-data", s.getUserdata(privateUserdata))
+	r.PUT("/users/:id/private-data", s.writeUserdata(privateUserdata, false))
+	r.PATCH("/users/:id/private-data", s.writeUserdata(privateUserdata, true))
+
+	r.GET("/users/:id/sessions", s.listSessions)
+	r.DELETE("/users/:id/sessions", s.revokeSessions)
+
+	r.GET("/users/:id/roles", s.listUserRoles)
+	r.PUT("/users/:id/roles/:role", s.assignRole)
+	r.DELETE("/users/:id/roles/:role", s.removeRole)
+
+	r.GET("/roles", s.listRoles)
+	r.GET("/roles/:role", s.getRole)
+	r.PUT("/roles/:role", s.putRole)
+	r.DELETE("/roles/:role", s.deleteRole)
+
+	r.GET("/keys", s.listKeys)
+	r.POST("/keys/:kid/promote", s.promoteKey)
+	r.POST("/keys/:kid/retire", s.retireKey)
+
+	err := r.Run(fmt.Sprintf("0.0.0.0:%d", s.config.SystemService.Port))
+
+	if err != nil {
+		s.log.Error("error running system service", "error", err)
+	}
 }
@@ -0,0 +1,52 @@
+package sysservice
+
+import (
+	"net/http"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Service) listSessions(c *gin.Context) {
+	user, ok := s.loadUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.db.ListSessionsFor(user.ID)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("list sessions error", "error", err)
+		return
+	}
+
+	list := make([]core.Session, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, session.ToCore())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": list,
+	})
+}
+
+// revokeSessions revokes all sessions of a user. Existing auth tokens stay valid until they expire, but can't be refreshed anymore.
+func (s *Service) revokeSessions(c *gin.Context) {
+	user, ok := s.loadUser(c)
+	if !ok {
+		return
+	}
+
+	n, err := s.db.RevokeSessionsFor(user.ID)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("revoke sessions error", "error", err)
+		return
+	}
+
+	s.log.Info("revoked sessions", "user", user.ID, "count", n, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": n,
+	})
+}
@@ -0,0 +1,124 @@
+package sysservice
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/5000K/kingdom-auth/db"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// userdataKind selects whether the userdata endpoints operate on the public or the private userdata of a user.
+type userdataKind struct {
+	name string
+	get  func(u *db.User) (db.UserData, error)
+	set  func(u *db.User, ud db.UserData) error
+}
+
+var (
+	publicUserdata = userdataKind{
+		name: "public",
+		get:  (*db.User).GetPublicUserdata,
+		set:  (*db.User).SetPublicUserdata,
+	}
+
+	privateUserdata = userdataKind{
+		name: "private",
+		get:  (*db.User).GetPrivateUserdata,
+		set:  (*db.User).SetPrivateUserdata,
+	}
+)
+
+// loadUser loads the user referenced by the :id route parameter. It writes an error response and returns false on failure.
+func (s *Service) loadUser(c *gin.Context) (*db.User, bool) {
+	id, ok := parseUserId(c)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := s.db.GetUser(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "user not found",
+			})
+			return nil, false
+		}
+
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("get user error", "error", err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (s *Service) getUserdata(kind userdataKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.loadUser(c)
+		if !ok {
+			return
+		}
+
+		ud, err := kind.get(user)
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("read userdata error", "user", user.ID, "kind", kind.name, "error", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, ud)
+	}
+}
+
+// writeUserdata returns a handler that replaces (PUT) or merge-patches (PATCH) the userdata of a user.
+func (s *Service) writeUserdata(kind userdataKind, patch bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body map[string]any
+
+		err := c.ShouldBindJSON(&body)
+		if err != nil || body == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "body must be a json object",
+			})
+			return
+		}
+
+		user, ok := s.loadUser(c)
+		if !ok {
+			return
+		}
+
+		ud := db.UserData(body)
+
+		if patch {
+			current, err := kind.get(user)
+			if err != nil {
+				c.Writer.WriteHeader(http.StatusInternalServerError)
+				s.log.Info("read userdata error", "user", user.ID, "kind", kind.name, "error", err)
+				return
+			}
+
+			ud = current.MergePatch(body)
+		}
+
+		err = kind.set(user, ud)
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("write userdata error", "user", user.ID, "kind", kind.name, "error", err)
+			return
+		}
+
+		err = s.db.UpdateUser(user)
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("update user error", "user", user.ID, "error", err)
+			return
+		}
+
+		s.log.Info("updated userdata", "user", user.ID, "kind", kind.name, "token", c.GetString(systemTokenKey))
+
+		c.JSON(http.StatusOK, ud)
+	}
+}
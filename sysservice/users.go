@@ -0,0 +1,148 @@
+package sysservice
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/5000K/kingdom-auth/core"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// parseUserId reads the :id route parameter. It writes an error response and returns false if the parameter is not a valid user id.
+func parseUserId(c *gin.Context) (uint32, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid user id",
+		})
+		return 0, false
+	}
+
+	return uint32(id), true
+}
+
+func (s *Service) listUsers(c *gin.Context) {
+	limit := defaultPageSize
+	var cursor uint64 = 0
+
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid limit",
+			})
+			return
+		}
+
+		limit = min(parsed, maxPageSize)
+	}
+
+	if cur := c.Query("cursor"); cur != "" {
+		parsed, err := strconv.ParseUint(cur, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid cursor",
+			})
+			return
+		}
+
+		cursor = parsed
+	}
+
+	users, err := s.db.ListUsers(uint(cursor), limit)
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("list users error", "error", err)
+		return
+	}
+
+	list := make([]*core.User, 0, len(users))
+	for _, u := range users {
+		user, err := u.ToCore()
+		if err != nil {
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			s.log.Info("convert user error", "user", u.ID, "error", err)
+			return
+		}
+
+		list = append(list, user)
+	}
+
+	// only hand out a cursor if there might be another page
+	var next any = nil
+	if len(users) == limit {
+		next = strconv.FormatUint(uint64(users[len(users)-1].ID), 10)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       list,
+		"next_cursor": next,
+	})
+}
+
+func (s *Service) getUser(c *gin.Context) {
+	id, ok := parseUserId(c)
+	if !ok {
+		return
+	}
+
+	u, err := s.db.GetUser(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "user not found",
+			})
+			return
+		}
+
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("get user error", "error", err)
+		return
+	}
+
+	user, err := u.ToCore()
+	if err != nil {
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("convert user error", "user", u.ID, "error", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (s *Service) deleteUser(c *gin.Context) {
+	id, ok := parseUserId(c)
+	if !ok {
+		return
+	}
+
+	hard := c.Query("hard") == "true"
+
+	err := s.db.DeleteUser(id, hard)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "user not found",
+			})
+			return
+		}
+
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		s.log.Info("delete user error", "error", err)
+		return
+	}
+
+	s.log.Info("deleted user", "user", id, "hard", hard, "token", c.GetString(systemTokenKey))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user deleted",
+	})
+}